@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mikogd/maokai"
+)
+
+var ACCURATERIP_URL = "http://www.accuraterip.com/accuraterip"
+
+// Samples in a CD sector, 588 stereo samples make up 1/75th of a second
+const SAMPLES_PER_SECTOR = 588
+
+// AccurateRip skips the first and last 5 sectors of a disc as drives with different offsets can't read them
+const ACCURATERIP_SKIPPED_SECTORS = 5
+
+// The IDs AccurateRip stores a disc under, derived from the TOC
+type AccurateRipDiscID struct {
+	TrackCount int
+	ID1        uint32
+	ID2        uint32
+	CDDBID     uint32
+}
+
+func computeAccurateRipDiscID(toc DiscTOC) AccurateRipDiscID {
+	discID := AccurateRipDiscID{TrackCount: toc.TrackCount()}
+
+	// AccurateRip uses offsets without the lead-in while the CDDB ID uses them with it
+	var cddbSum uint32
+	for i, offset := range toc.Offsets {
+		trackOffset := uint32(offset - LEAD_IN_SECTORS)
+		discID.ID1 += trackOffset
+		discID.ID2 += max(trackOffset, 1) * uint32(i+1)
+
+		for seconds := offset / SECTORS_PER_SECOND; seconds > 0; seconds /= 10 {
+			cddbSum += uint32(seconds % 10)
+		}
+	}
+
+	leadOut := uint32(toc.LeadOut - LEAD_IN_SECTORS)
+	discID.ID1 += leadOut
+	discID.ID2 += max(leadOut, 1) * uint32(toc.TrackCount()+1)
+
+	discLength := uint32(toc.LeadOut/SECTORS_PER_SECOND - toc.Offsets[0]/SECTORS_PER_SECOND)
+	discID.CDDBID = (cddbSum%255)<<24 | discLength<<8 | uint32(toc.TrackCount())
+
+	return discID
+}
+
+// Path of the disc's entry relative to the AccurateRip base URL
+func (id AccurateRipDiscID) Path() string {
+	return fmt.Sprintf("%x/%x/%x/dBAR-%03d-%08x-%08x-%08x.bin",
+		id.ID1&0xF, id.ID1>>4&0xF, id.ID1>>8&0xF, id.TrackCount, id.ID1, id.ID2, id.CDDBID)
+}
+
+// Checksums submitted for a track by one pressing of the disc
+type AccurateRipEntry struct {
+	Confidence uint8
+	CRC        uint32
+	Frame450   uint32
+}
+
+// All the pressings AccurateRip has for the disc, each holds one entry per track
+type AccurateRipResponse struct {
+	Pressings [][]AccurateRipEntry
+}
+
+func parseAccurateRipResponse(data []byte, discID AccurateRipDiscID) (AccurateRipResponse, error) {
+	response := AccurateRipResponse{}
+
+	const headerSize = 13
+	const entrySize = 9
+	for position := 0; position < len(data); {
+		if position+headerSize > len(data) {
+			return response, errors.New("AccurateRip response has a truncated header")
+		}
+
+		trackCount := int(data[position])
+		ID1 := binary.LittleEndian.Uint32(data[position+1:])
+		ID2 := binary.LittleEndian.Uint32(data[position+5:])
+		CDDBID := binary.LittleEndian.Uint32(data[position+9:])
+		position += headerSize
+
+		if position+trackCount*entrySize > len(data) {
+			return response, errors.New("AccurateRip response has truncated track entries")
+		}
+
+		entries := make([]AccurateRipEntry, trackCount)
+		for i := range entries {
+			entries[i] = AccurateRipEntry{
+				Confidence: data[position],
+				CRC:        binary.LittleEndian.Uint32(data[position+1:]),
+				Frame450:   binary.LittleEndian.Uint32(data[position+5:]),
+			}
+			position += entrySize
+		}
+
+		if trackCount != discID.TrackCount || ID1 != discID.ID1 || ID2 != discID.ID2 || CDDBID != discID.CDDBID {
+			continue
+		}
+
+		response.Pressings = append(response.Pressings, entries)
+	}
+
+	return response, nil
+}
+
+// Fetches the disc's checksums from ACCURATERIP_URL, which may also be a file:// URL or directory holding a copy of
+// the database. A disc missing from the database returns an empty response.
+func fetchAccurateRip(discID AccurateRipDiscID, logger maokai.Logger) (AccurateRipResponse, error) {
+	baseURL, err := url.Parse(ACCURATERIP_URL)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to parse AccurateRip URL %s: %s", ACCURATERIP_URL, err)
+		return AccurateRipResponse{}, errors.New(errorMessage)
+	}
+
+	var data []byte
+	if baseURL.Scheme == "" || baseURL.Scheme == "file" {
+		filePath := filepath.Join(baseURL.Path, discID.Path())
+		logger.CreateLogf("Reading AccurateRip entry %s", filePath)
+
+		data, err = os.ReadFile(filePath)
+		if errors.Is(err, os.ErrNotExist) {
+			return AccurateRipResponse{}, nil
+		} else if err != nil {
+			return AccurateRipResponse{}, err
+		}
+	} else {
+		URLString := strings.TrimSuffix(ACCURATERIP_URL, "/") + "/" + discID.Path()
+		logger.CreateLogf("Fetching AccurateRip entry %s", URLString)
+
+		req, err := http.NewRequest("GET", URLString, nil)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error creating request: %s", err)
+			return AccurateRipResponse{}, errors.New(errorMessage)
+		}
+		req.Header.Set("User-Agent", USER_AGENT)
+
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Do(req)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error making request: %s", err)
+			return AccurateRipResponse{}, errors.New(errorMessage)
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotFound {
+			return AccurateRipResponse{}, nil
+		} else if resp.StatusCode != http.StatusOK {
+			errorMessage := fmt.Sprintf("AccurateRip returned %s", resp.Status)
+			return AccurateRipResponse{}, errors.New(errorMessage)
+		}
+
+		data, err = io.ReadAll(resp.Body)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error reading body: %s", err)
+			return AccurateRipResponse{}, errors.New(errorMessage)
+		}
+	}
+
+	return parseAccurateRipResponse(data, discID)
+}
+
+// Computes the AccurateRip v1 and v2 checksums of a track's samples. The first and last track skip the samples
+// around the start and end of the disc.
+func computeAccurateRipCRCs(samples []uint32, isFirstTrack bool, isLastTrack bool) (uint32, uint32) {
+	checkFrom := 1
+	checkTo := len(samples)
+	if isFirstTrack {
+		checkFrom += ACCURATERIP_SKIPPED_SECTORS*SAMPLES_PER_SECTOR - 1
+	}
+	if isLastTrack {
+		checkTo -= ACCURATERIP_SKIPPED_SECTORS * SAMPLES_PER_SECTOR
+	}
+
+	var CRCv1, CRCv2 uint32
+	for i, sample := range samples {
+		position := i + 1
+		if position < checkFrom || position > checkTo {
+			continue
+		}
+
+		product := uint64(sample) * uint64(position)
+		CRCv1 += uint32(product)
+		CRCv2 += uint32(product) + uint32(product>>32)
+	}
+
+	return CRCv1, CRCv2
+}
+
+// The AccurateRip result of a single track
+type AccurateRipTrackResult struct {
+	TrackNumber int
+	CRCv1       uint32
+	CRCv2       uint32
+	// Number of submissions matching the track, 0 when it didn't match any
+	Confidence int
+	// The AccurateRip version that matched, 0 when neither did
+	Version int
+}
+
+func (result AccurateRipTrackResult) Accurate() bool {
+	return result.Version != 0
+}
+
+type AccurateRipResult struct {
+	DiscID AccurateRipDiscID
+	// Set when the database couldn't be queried, the tracks then only hold their checksums
+	LookupError error
+	// False when the disc isn't in the AccurateRip database so no track could be verified
+	InDatabase bool
+	Tracks     []AccurateRipTrackResult
+}
+
+// Tracks that didn't match any submission for a disc that is in the database
+func (result AccurateRipResult) FailedTracks() []int {
+	failedTracks := []int{}
+	if !result.InDatabase {
+		return failedTracks
+	}
+
+	for _, track := range result.Tracks {
+		if !track.Accurate() {
+			failedTracks = append(failedTracks, track.TrackNumber)
+		}
+	}
+
+	return failedTracks
+}
+
+func compareAccurateRip(result *AccurateRipTrackResult, index int, response AccurateRipResponse) {
+	for _, pressing := range response.Pressings {
+		if index >= len(pressing) {
+			continue
+		}
+
+		entry := pressing[index]
+		switch entry.CRC {
+		case result.CRCv2:
+			result.Version = 2
+			result.Confidence += int(entry.Confidence)
+		case result.CRCv1:
+			if result.Version == 0 {
+				result.Version = 1
+			}
+			result.Confidence += int(entry.Confidence)
+		}
+	}
+}
+
+// Computes the track's checksums and compares them against the pressings in the response
+func checkAccurateRipTrack(samples []uint32, trackNumber int, toc DiscTOC, response AccurateRipResponse) AccurateRipTrackResult {
+	result := AccurateRipTrackResult{TrackNumber: trackNumber}
+	result.CRCv1, result.CRCv2 = computeAccurateRipCRCs(
+		samples, trackNumber == toc.FirstTrack, trackNumber == toc.LastTrack)
+	compareAccurateRip(&result, trackNumber-toc.FirstTrack, response)
+
+	return result
+}
+
+func (result AccurateRipTrackResult) String() string {
+	if result.Accurate() {
+		return fmt.Sprintf("Track %02d: accurately ripped (v%d, confidence %d) [v1 %08X, v2 %08X]",
+			result.TrackNumber, result.Version, result.Confidence, result.CRCv1, result.CRCv2)
+	}
+
+	return fmt.Sprintf("Track %02d: no match [v1 %08X, v2 %08X]", result.TrackNumber, result.CRCv1, result.CRCv2)
+}
+
+func reportAccurateRip(result AccurateRipResult, logger maokai.Logger) {
+	if result.LookupError != nil {
+		errorMessage := fmt.Sprintf("Failed to verify rip with AccurateRip: %s", result.LookupError)
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+	} else if !result.InDatabase {
+		message := "Disc is not in the AccurateRip database, tracks can't be verified"
+		log.Println(message)
+		logger.CreateLog(message)
+	}
+
+	for _, track := range result.Tracks {
+		log.Println(track)
+		logger.CreateLog(track.String())
+	}
+
+	if failedTracks := result.FailedTracks(); len(failedTracks) > 0 {
+		errorMessage := fmt.Sprintf("Tracks %v did not match AccurateRip and should be re-ripped", failedTracks)
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+	}
+}
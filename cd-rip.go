@@ -6,12 +6,14 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mikogd/maokai"
 )
 
-func convertToFlac(fileName string, logger maokai.Logger) error {
+func convertToFlac(fileName string, progress *ProgressReporter, logger maokai.Logger) error {
   fileNameTokens := strings.Split(fileName, ".")
   if len(fileNameTokens) != 3 {
     errorMessage := fmt.Sprintf("Split file name contained less than 3 items: %v\n", fileNameTokens)
@@ -19,45 +21,361 @@ func convertToFlac(fileName string, logger maokai.Logger) error {
     return errors.New(errorMessage)
   }
 
+	trackNumber, _ := strconv.Atoi(strings.TrimPrefix(fileNameTokens[0], "track"))
+
+	// The duration lets ffmpeg's progress be turned into a percentage, 44.1kHz 16 bit stereo is 176400 bytes a second
+	var duration time.Duration
+	if info, err := os.Stat(fileName); err == nil {
+		duration = time.Duration(float64(info.Size()) / 176400 * float64(time.Second))
+	}
+
   newFileName := fmt.Sprintf("%s.flac", fileNameTokens[0])
-	logger.CreateLog(fmt.Sprintf("Running command ffmpeg -i %s -c:a flac -compression_level 5", newFileName))
-  cmd := exec.Command("ffmpeg", "-i", fileName, "-c:a", "flac", "-compression_level", "5", newFileName)
-  cmd.Stdout = os.Stdout
+	logger.CreateLog(fmt.Sprintf("Running command ffmpeg -i %s -c:a flac -compression_level 5 -progress pipe:1 %s", fileName, newFileName))
+	cmd := exec.Command("ffmpeg", "-nostats", "-loglevel", "error", "-i", fileName,
+		"-c:a", "flac", "-compression_level", "5", "-progress", "pipe:1", newFileName)
   cmd.Stderr = os.Stderr
 
-  log.Printf("Converting %s to %s\n", fileName, newFileName)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
 	logger.CreateLog(fmt.Sprintf("Converting %s to %s", fileName, newFileName))
-  if err := cmd.Run(); err != nil {
-    return err
-  }
-  log.Printf("Converted %s to %s\n", fileName, newFileName)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanErr := scanFFmpegProgress(stdout, trackNumber, duration, progress)
+
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	if scanErr != nil {
+		return scanErr
+	}
+
 	logger.CreateLog(fmt.Sprintf("Converted %s to %s", fileName, newFileName))
 
   return nil
 }
 
-func RipCD(destPath string, logger maokai.Logger) error {
-	CDROM, err := getCDDriveDeviceName(logger)
+// Describes cdparanoia's error correction in the rip log, sona always rips with full paranoia
+const PARANOIA_MODE = "Full paranoia (cdparanoia default)"
+
+// How the disc is read by cdparanoia
+type RipSettings struct {
+	Device string
+	// Read offset of the drive in samples, passed to cdparanoia's -O
+	ReadOffset int
+	// Read every track twice and compare the CRC32s of the reads
+	SecureMode bool
+	// Extra reads of a track allowed in secure mode before giving up on reads matching
+	MaxRetries int
+	// Rip the disc into a single IMAGE_WAV_FILE_NAME instead of a file per track
+	Image bool
+	// Receives progress events while ripping and converting, may be nil
+	Progress *ProgressReporter
+}
+
+// What was found while ripping and checking a single track
+type TrackReport struct {
+	TrackNumber int
+	// CRC32 of the track's PCM data
+	CopyCRC uint32
+	// CRC32 of the earlier read the copy matched in secure mode
+	TestCRC uint32
+	// Re-reads needed in secure mode before the copy matched an earlier read
+	Retries int
+	// Highest sample level as a fraction of full scale
+	Peak float64
+	// Sectors relative to the start of the track that cdparanoia couldn't read correctly
+	SuspiciousPositions []int
+	AccurateRip         AccurateRipTrackResult
+}
+
+type RipReport struct {
+	Settings    RipSettings
+	Tracks      []TrackReport
+	AccurateRip AccurateRipResult
+	// Audio found in the pregap of track 1, nil when the disc has no long pregap
+	HiddenTrack *HiddenTrackReport
+}
+
+// Runs cdparanoia with -e and collects the positions it reported as suspicious for each track
+func runParanoia(args []string, progress *paranoiaProgress, logger maokai.Logger) (map[int][]ParanoiaEvent, error) {
+	args = append([]string{"-e"}, args...)
+	logger.CreateLog(fmt.Sprintf("Running command cdparanoia %s", strings.Join(args, " ")))
+	cmd := exec.Command("cdparanoia", args...)
+	cmd.Stdout = os.Stdout
+
+	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	logger.CreateLog(fmt.Sprintf("Running command cdparanoia -d %s -Bw", CDROM))
-  cmd := exec.Command("cdparanoia", "-d", CDROM, "-Bw")
-  cmd.Stdout = os.Stdout
-  cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
 
-  if err := cmd.Run(); err != nil {
-    errorMessage := fmt.Sprintf("Failed to run cdparanoia -Bw in %s: %s\n", destPath, err)
-		logger.CreateLog(strings.Trim(errorMessage, "\n"))
-    return errors.New(errorMessage)
-  }
+	// Every run reports its track as started, in secure mode this restarts the track's bar for each read
+	progress.reset()
+	suspiciousEvents := map[int][]ParanoiaEvent{}
+	// The progress events replace cdparanoia's own output on the terminal so it only goes to the log
+	scanErr := scanParanoiaOutput(stderr, loggerWriter{logger}, func(event ParanoiaEvent) {
+		if progress.wholeDisc {
+			event.TrackNumber = progress.toc.TrackAtSector(event.Sector())
+		}
+		progress.onEvent(event)
+
+		if event.IsSuspicious() {
+			logger.CreateLogf("cdparanoia reported %s on track %d at sector %d", event.Name, event.TrackNumber, event.Sector())
+			suspiciousEvents[event.TrackNumber] = append(suspiciousEvents[event.TrackNumber], event)
+		}
+	})
+
+	if err := cmd.Wait(); err != nil {
+		return nil, err
+	}
+
+	return suspiciousEvents, scanErr
+}
+
+// The outcome of reading a track in secure mode
+type secureRead struct {
+	TestCRC uint32
+	CopyCRC uint32
+	Retries int
+	// Keyed by track number, an image read holds the events of every track
+	SuspiciousEvents map[int][]ParanoiaEvent
+}
+
+// Reads a span of the disc, in cdparanoia's span syntax, into fileName returning the CRC32 of its PCM data
+func readSpan(
+	settings RipSettings,
+	span string,
+	fileName string,
+	progress *paranoiaProgress,
+	logger maokai.Logger,
+) (uint32, map[int][]ParanoiaEvent, error) {
+	args := []string{"-d", settings.Device, "-O", strconv.Itoa(settings.ReadOffset), "-w", span, fileName}
+	suspiciousEvents, err := runParanoia(args, progress, logger)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	samples, err := readWAVSamples(fileName)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	CRC, _ := computePCMStats(samples)
+
+	return CRC, suspiciousEvents, nil
+}
+
+// Reads the span until two reads have the same CRC32, giving up after settings.MaxRetries re-reads. The matching
+// read is left in copyFileName. name describes the span in messages e.g. "track 01".
+func secureReadSpan(
+	settings RipSettings,
+	name string,
+	span string,
+	copyFileName string,
+	progress *paranoiaProgress,
+	logger maokai.Logger,
+) (secureRead, error) {
+	testFileName := strings.Replace(copyFileName, ".cdda.wav", ".test.wav", 1)
+	defer os.Remove(testFileName)
+
+	log.Printf("Test reading %s\n", name)
+	testCRC, _, err := readSpan(settings, span, testFileName, progress, logger)
+	if err != nil {
+		return secureRead{}, err
+	}
+
+	earlierCRCs := []uint32{testCRC}
+	for retries := 0; retries <= settings.MaxRetries; retries++ {
+		log.Printf("Copy reading %s\n", name)
+		copyCRC, suspiciousEvents, err := readSpan(settings, span, copyFileName, progress, logger)
+		if err != nil {
+			return secureRead{}, err
+		}
+
+		for _, earlierCRC := range earlierCRCs {
+			if copyCRC == earlierCRC {
+				return secureRead{
+					TestCRC:          earlierCRC,
+					CopyCRC:          copyCRC,
+					Retries:          retries,
+					SuspiciousEvents: suspiciousEvents,
+				}, nil
+			}
+		}
+
+		errorMessage := fmt.Sprintf("Copy CRC %08X of %s doesn't match earlier reads %08X (retry %d of %d)",
+			copyCRC, name, earlierCRCs, retries, settings.MaxRetries)
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+
+		earlierCRCs = append(earlierCRCs, copyCRC)
+	}
+
+	errorMessage := fmt.Sprintf("Reads of %s didn't match after %d retries, CRCs %08X",
+		name, settings.MaxRetries, earlierCRCs)
+	return secureRead{}, errors.New(errorMessage)
+}
+
+// Rips every track with test and copy reads, returning the reads keyed by track number
+func secureRipCD(settings RipSettings, toc DiscTOC, logger maokai.Logger) (map[int]secureRead, error) {
+	progress := &paranoiaProgress{reporter: settings.Progress, toc: toc}
+
+	reads := map[int]secureRead{}
+	for trackNumber := toc.FirstTrack; trackNumber <= toc.LastTrack; trackNumber++ {
+		name := fmt.Sprintf("track %02d", trackNumber)
+		copyFileName := fmt.Sprintf("track%02d.cdda.wav", trackNumber)
+		read, err := secureReadSpan(settings, name, strconv.Itoa(trackNumber), copyFileName, progress, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		message := fmt.Sprintf("Track %02d: test CRC %08X, copy CRC %08X, copy OK", trackNumber, read.TestCRC, read.CopyCRC)
+		if read.Retries > 0 {
+			message = fmt.Sprintf("%s after %d retries", message, read.Retries)
+		}
+		log.Println(message)
+		logger.CreateLog(message)
+
+		reads[trackNumber] = read
+	}
+
+	return reads, nil
+}
+
+// Reads a track ripped into its own trackNN.cdda.wav
+func readTrackFileSamples(trackNumber int) ([]uint32, error) {
+	return readWAVSamples(fmt.Sprintf("track%02d.cdda.wav", trackNumber))
+}
+
+// Reads every ripped track with readSamples computing its checksums, peak level and AccurateRip result
+func checkRippedTracks(
+	toc DiscTOC,
+	suspiciousEvents map[int][]ParanoiaEvent,
+	readSamples func(trackNumber int) ([]uint32, error),
+	logger maokai.Logger,
+) RipReport {
+	report := RipReport{}
+
+	discID := computeAccurateRipDiscID(toc)
+	logger.CreateLogf("AccurateRip disc ID: %s", discID.Path())
+	response, err := fetchAccurateRip(discID, logger)
+	report.AccurateRip = AccurateRipResult{
+		DiscID:      discID,
+		LookupError: err,
+		InDatabase:  len(response.Pressings) > 0,
+	}
+
+	for trackNumber := toc.FirstTrack; trackNumber <= toc.LastTrack; trackNumber++ {
+		trackReport := TrackReport{TrackNumber: trackNumber}
+
+		samples, err := readSamples(trackNumber)
+		if err != nil {
+			// Data tracks aren't ripped so there is nothing to check
+			logger.CreateErrorLogf("Failed to read track %02d: %s", trackNumber, err)
+			continue
+		}
+
+		trackReport.CopyCRC, trackReport.Peak = computePCMStats(samples)
+		trackReport.AccurateRip = checkAccurateRipTrack(samples, trackNumber, toc, response)
+
+		trackStart := toc.TrackOffset(trackNumber) - LEAD_IN_SECTORS
+		for _, event := range suspiciousEvents[trackNumber] {
+			trackReport.SuspiciousPositions = append(trackReport.SuspiciousPositions, event.Sector()-trackStart)
+		}
+
+		report.Tracks = append(report.Tracks, trackReport)
+		report.AccurateRip.Tracks = append(report.AccurateRip.Tracks, trackReport.AccurateRip)
+	}
+
+	return report
+}
+
+// Rips the disc into destPath, verifies the tracks against AccurateRip and converts them to flac
+func RipCD(settings RipSettings, toc DiscTOC, destPath string, logger maokai.Logger) (RipReport, error) {
+	hiddenTrack, err := ripHiddenTrack(settings, toc, logger)
+	if err != nil {
+		// Not every drive can read the pregap, the rest of the disc can still be ripped
+		errorMessage := fmt.Sprintf("Failed to rip hidden track before track 1: %s", err)
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+		os.Remove(HTOA_FILE_NAME)
+		hiddenTrack = nil
+	}
+
+	var suspiciousEvents map[int][]ParanoiaEvent
+	var secureReads map[int]secureRead
+	var imageRead *secureRead
+	readSamples := readTrackFileSamples
+	if settings.Image {
+		suspiciousEvents, imageRead, err = ripImage(settings, toc, logger)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Failed to rip disc image in %s: %s\n", destPath, err)
+			logger.CreateLog(strings.Trim(errorMessage, "\n"))
+			return RipReport{}, errors.New(errorMessage)
+		}
+
+		readSamples = imageTrackSamples(toc)
+	} else if settings.SecureMode {
+		secureReads, err = secureRipCD(settings, toc, logger)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Failed to securely rip in %s: %s\n", destPath, err)
+			logger.CreateLog(strings.Trim(errorMessage, "\n"))
+			return RipReport{}, errors.New(errorMessage)
+		}
+
+		suspiciousEvents = map[int][]ParanoiaEvent{}
+		for trackNumber, read := range secureReads {
+			suspiciousEvents[trackNumber] = read.SuspiciousEvents[trackNumber]
+		}
+	} else {
+		args := []string{"-d", settings.Device, "-O", strconv.Itoa(settings.ReadOffset), "-Bw"}
+		progress := &paranoiaProgress{reporter: settings.Progress, toc: toc}
+		suspiciousEvents, err = runParanoia(args, progress, logger)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Failed to run cdparanoia -Bw in %s: %s\n", destPath, err)
+			logger.CreateLog(strings.Trim(errorMessage, "\n"))
+			return RipReport{}, errors.New(errorMessage)
+		}
+	}
+
+	logger.CreateLog("Verifying ripped songs with AccurateRip")
+	report := checkRippedTracks(toc, suspiciousEvents, readSamples, logger)
+	report.Settings = settings
+	report.HiddenTrack = hiddenTrack
+
+	for i, track := range report.Tracks {
+		if read, found := secureReads[track.TrackNumber]; found {
+			report.Tracks[i].TestCRC = read.TestCRC
+			report.Tracks[i].Retries = read.Retries
+		} else if imageRead != nil {
+			// Both reads of the image matched so every track's test read matched its copy
+			report.Tracks[i].TestCRC = track.CopyCRC
+			report.Tracks[i].Retries = imageRead.Retries
+		}
+	}
+	reportAccurateRip(report.AccurateRip, logger)
+
+	for _, track := range report.Tracks {
+		if len(track.SuspiciousPositions) > 0 {
+			errorMessage := fmt.Sprintf("Track %02d has %d suspicious positions", track.TrackNumber, len(track.SuspiciousPositions))
+			log.Println(errorMessage)
+			logger.CreateErrorLog(errorMessage)
+		}
+	}
 
   currentDirectory, err := os.ReadDir(".")
   if err != nil {
     errorMessage := fmt.Sprintf("Failed to read current directory: %s\n", err)
 		logger.CreateLog(strings.Trim(errorMessage, "\n"))
-    return errors.New(errorMessage)
+    return report, errors.New(errorMessage)
   }
 
 	logger.CreateLog("Converting ripped songs to flac")
@@ -71,12 +389,12 @@ func RipCD(destPath string, logger maokai.Logger) error {
       continue
     }
 
-    if err := convertToFlac(entry.Name(), logger); err != nil {
+    if err := convertToFlac(entry.Name(), settings.Progress, logger); err != nil {
       errorMessage := fmt.Sprintf("Failed to convert %s to flac: %s\n", entry.Name(), err)
 			logger.CreateLog(strings.Trim(errorMessage, "\n"))
-      return errors.New(errorMessage)
+      return report, errors.New(errorMessage)
     }
   }
 
-  return nil
+  return report, nil
 }
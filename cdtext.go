@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mikogd/maokai"
+)
+
+type CDTextTrack struct {
+	Title      string
+	Performer  string
+	Songwriter string
+	Composer   string
+}
+
+// CD-Text of a disc in its first language
+type CDText struct {
+	Title     string
+	Performer string
+	// Keyed by track number
+	Tracks map[int]CDTextTrack
+}
+
+// Whether the disc has enough CD-Text to tag the rip with
+func (cdText CDText) IsEmpty() bool {
+	if cdText.Title == "" {
+		return true
+	}
+
+	for _, track := range cdText.Tracks {
+		if track.Title != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reads the CD-Text of a disc
+type CDTextReader interface {
+	ReadCDText(device string) (CDText, error)
+}
+
+// Reads CD-Text from the drive with `cdrdao read-toc`
+type CdrdaoCDTextReader struct {
+	TOC    DiscTOC
+	Logger maokai.Logger
+}
+
+// Reads CD-Text from a toc file captured with `cdrdao read-toc`, the device is ignored
+type CdrdaoDumpCDTextReader struct {
+	Path       string
+	FirstTrack int
+}
+
+var cdTextFieldPattern = regexp.MustCompile(`^(TITLE|PERFORMER|SONGWRITER|COMPOSER) "(.*)"$`)
+
+// Undoes the escaping cdrdao writes CD-Text strings with. Characters outside ASCII are octal escapes of ISO 8859-1
+// bytes, the character set CD-Text uses.
+func unescapeCdrdaoString(value string) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 >= len(value) {
+			builder.WriteByte(value[i])
+			continue
+		}
+
+		if i+3 < len(value) {
+			if code, err := strconv.ParseUint(value[i+1:i+4], 8, 8); err == nil {
+				builder.WriteRune(rune(code))
+				i += 3
+				continue
+			}
+		}
+
+		builder.WriteByte(value[i+1])
+		i++
+	}
+
+	return builder.String()
+}
+
+// Parses the CD_TEXT blocks of a toc file written by `cdrdao read-toc`, keeping the first language. The block before
+// the first TRACK is the disc's. Tracks are numbered in the order they appear from firstTrack.
+func parseCdrdaoCDText(reader io.Reader, firstTrack int) (CDText, error) {
+	cdText := CDText{Tracks: map[int]CDTextTrack{}}
+
+	trackNumber := firstTrack - 1
+	inFirstLanguage := false
+	languagesSeen := 0
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "TRACK "):
+			trackNumber++
+			languagesSeen = 0
+			continue
+		case strings.HasPrefix(line, "LANGUAGE "):
+			inFirstLanguage = languagesSeen == 0
+			languagesSeen++
+			continue
+		case line == "}":
+			inFirstLanguage = false
+			continue
+		}
+
+		match := cdTextFieldPattern.FindStringSubmatch(line)
+		if match == nil || !inFirstLanguage {
+			continue
+		}
+
+		value := strings.TrimSpace(unescapeCdrdaoString(match[2]))
+		if trackNumber < firstTrack {
+			switch match[1] {
+			case "TITLE":
+				cdText.Title = value
+			case "PERFORMER":
+				cdText.Performer = value
+			}
+			continue
+		}
+
+		track := cdText.Tracks[trackNumber]
+		switch match[1] {
+		case "TITLE":
+			track.Title = value
+		case "PERFORMER":
+			track.Performer = value
+		case "SONGWRITER":
+			track.Songwriter = value
+		case "COMPOSER":
+			track.Composer = value
+		}
+		cdText.Tracks[trackNumber] = track
+	}
+
+	return cdText, scanner.Err()
+}
+
+func (reader CdrdaoCDTextReader) ReadCDText(device string) (CDText, error) {
+	if _, err := exec.LookPath("cdrdao"); err != nil {
+		return CDText{}, errors.New("cdrdao isn't installed, CD-Text can't be read")
+	}
+
+	tempDirectory, err := os.MkdirTemp("", "sona-cdtext-")
+	if err != nil {
+		return CDText{}, err
+	}
+	defer os.RemoveAll(tempDirectory)
+
+	// --fast-toc skips the slow search for pregaps and index marks, CD-Text is still read
+	tocFileName := filepath.Join(tempDirectory, "disc.toc")
+	args := []string{"read-toc", "--fast-toc", "--device", device, "--datafile", "disc.bin", tocFileName}
+	reader.Logger.CreateLogf("Running command cdrdao %s", strings.Join(args, " "))
+	cmd := exec.Command("cdrdao", args...)
+	cmd.Stdout = loggerWriter{reader.Logger}
+	cmd.Stderr = loggerWriter{reader.Logger}
+
+	if err := cmd.Run(); err != nil {
+		errorMessage := fmt.Sprintf("Failed to run cdrdao read-toc: %s", err)
+		return CDText{}, errors.New(errorMessage)
+	}
+
+	return CdrdaoDumpCDTextReader{Path: tocFileName, FirstTrack: reader.TOC.FirstTrack}.ReadCDText(device)
+}
+
+func (reader CdrdaoDumpCDTextReader) ReadCDText(device string) (CDText, error) {
+	file, err := os.Open(reader.Path)
+	if err != nil {
+		return CDText{}, err
+	}
+	defer file.Close()
+
+	return parseCdrdaoCDText(file, reader.FirstTrack)
+}
+
+// Builds a release from the disc's CD-Text so it can be tagged like a provider's release. It has no ID and a single
+// medium at discNumber holding the disc's tracks.
+func releaseFromCDText(cdText CDText, disc DiscInfo, discNumber uint8) ReleaseInfo {
+	performerCredit := func(performer string) []CreditedArtist {
+		return []CreditedArtist{{Name: performer}}
+	}
+
+	release := ReleaseInfo{
+		Title:   cdText.Title,
+		Artists: performerCredit(cdText.Performer),
+	}
+
+	medium := MediumInfo{
+		Position: discNumber,
+		Format:   "CD",
+		DiscIDs:  []string{disc.ID},
+	}
+
+	for trackNumber := disc.TOC.FirstTrack; trackNumber <= disc.TOC.LastTrack; trackNumber++ {
+		cdTextTrack := cdText.Tracks[trackNumber]
+
+		title := cdTextTrack.Title
+		if title == "" {
+			title = fmt.Sprintf("Track %02d", trackNumber)
+		}
+
+		// Compilations credit each track, albums often only credit the disc
+		performer := cdTextTrack.Performer
+		if performer == "" {
+			performer = cdText.Performer
+		}
+
+		medium.Tracks = append(medium.Tracks, TrackInfo{
+			Number:  strconv.Itoa(trackNumber),
+			Title:   title,
+			Length:  uint32(disc.TOC.TrackSectors(trackNumber) * 1000 / SECTORS_PER_SECOND),
+			Artists: performerCredit(performer),
+		})
+	}
+
+	release.Media = []MediumInfo{medium}
+
+	return release
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestParseCdrdaoCDText(t *testing.T) {
+	// A hand-written fixture in the format cdrdao read-toc writes, with English and Japanese CD-Text
+	reader := CdrdaoDumpCDTextReader{Path: "testdata/cdrdao-cdtext.toc", FirstTrack: 1}
+	cdText, err := reader.ReadCDText("/dev/sr0")
+	if err != nil {
+		t.Fatalf("ReadCDText: %v", err)
+	}
+
+	if cdText.Title != "Café Society" {
+		t.Errorf("Title = %q, want %q", cdText.Title, "Café Society")
+	}
+	if cdText.Performer != `The Quiet "Ones"` {
+		t.Errorf("Performer = %q, want %q", cdText.Performer, `The Quiet "Ones"`)
+	}
+
+	want := map[int]CDTextTrack{
+		1: {Title: "Opening", Performer: `The Quiet "Ones"`, Songwriter: "A. Writer"},
+		2: {Title: "Closing Time", Performer: "Guest Singer", Composer: "B. Composer"},
+	}
+	if len(cdText.Tracks) != len(want) {
+		t.Fatalf("got %d tracks, want %d: %+v", len(cdText.Tracks), len(want), cdText.Tracks)
+	}
+	for trackNumber, track := range want {
+		if cdText.Tracks[trackNumber] != track {
+			t.Errorf("track %d = %+v, want %+v", trackNumber, cdText.Tracks[trackNumber], track)
+		}
+	}
+
+	if cdText.IsEmpty() {
+		t.Error("IsEmpty = true for a disc with titles")
+	}
+}
+
+func TestParseCdrdaoCDTextNumbersFromFirstTrack(t *testing.T) {
+	reader := CdrdaoDumpCDTextReader{Path: "testdata/cdrdao-cdtext.toc", FirstTrack: 5}
+	cdText, err := reader.ReadCDText("/dev/sr0")
+	if err != nil {
+		t.Fatalf("ReadCDText: %v", err)
+	}
+
+	if cdText.Tracks[5].Title != "Opening" || cdText.Tracks[6].Title != "Closing Time" {
+		t.Errorf("tracks = %+v, want Opening at 5 and Closing Time at 6", cdText.Tracks)
+	}
+}
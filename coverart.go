@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-flac/go-flac/v2"
+	"github.com/mikogd/maokai"
+)
+
+const (
+	// FLAC picture type of the front cover
+	FLAC_PICTURE_FRONT_COVER = 3
+	// Sizes of the thumbnails the Cover Art Archive serves alongside the original
+	COVER_ART_THUMBNAIL_SIZES = "250,500,1200"
+	COVER_ART_TIMEOUT         = 60 * time.Second
+)
+
+var (
+	// Set with COVER_ART_URL, a local stand-in works as long as it serves /release/<mbid>/front
+	COVER_ART_URL = "https://coverartarchive.org"
+	// Largest cover in pixels, the biggest thumbnail that fits is fetched. 0 fetches the original. Set with
+	// COVER_ART_MAX_SIZE.
+	COVER_ART_MAX_SIZE = 1200
+	// jpeg or png, set with COVER_ART_FORMAT
+	COVER_ART_FORMAT = "jpeg"
+)
+
+// A cover image ready to be saved and embedded
+type CoverArt struct {
+	Data     []byte
+	MIMEType string
+	Width    int
+	Height   int
+	// Bits per pixel
+	Depth int
+	// Colours in the palette of indexed images, 0 otherwise
+	Colors int
+}
+
+// Reads the cover art settings from the environment
+func loadCoverArtConfig() error {
+	if coverArtURL := os.Getenv("COVER_ART_URL"); coverArtURL != "" {
+		COVER_ART_URL = strings.TrimSuffix(coverArtURL, "/")
+	}
+
+	if maxSizeString := os.Getenv("COVER_ART_MAX_SIZE"); maxSizeString != "" {
+		maxSize, err := strconv.Atoi(maxSizeString)
+		if err != nil || (maxSize != 0 && maxSize < 250) {
+			errorMessage := fmt.Sprintf("COVER_ART_MAX_SIZE %s isn't 0 or a size of at least 250 pixels", maxSizeString)
+			return errors.New(errorMessage)
+		}
+		COVER_ART_MAX_SIZE = maxSize
+	}
+
+	switch format := strings.ToLower(os.Getenv("COVER_ART_FORMAT")); format {
+	case "":
+	case "jpeg", "jpg":
+		COVER_ART_FORMAT = "jpeg"
+	case "png":
+		COVER_ART_FORMAT = "png"
+	default:
+		errorMessage := fmt.Sprintf("Unknown COVER_ART_FORMAT \"%s\", use jpeg or png", format)
+		return errors.New(errorMessage)
+	}
+
+	return nil
+}
+
+// Name the cover is saved under in the album folder
+func coverArtFileName() string {
+	if COVER_ART_FORMAT == "png" {
+		return "cover.png"
+	}
+
+	return "cover.jpg"
+}
+
+// Path of the release's front cover under the Cover Art Archive, the largest thumbnail no bigger than maxSize
+func frontCoverPath(releaseID string, maxSize int) string {
+	frontPath := "/release/" + url.PathEscape(releaseID) + "/front"
+	if maxSize == 0 {
+		return frontPath
+	}
+
+	thumbnailSize := ""
+	for _, size := range strings.Split(COVER_ART_THUMBNAIL_SIZES, ",") {
+		if size, _ := strconv.Atoi(size); size <= maxSize {
+			thumbnailSize = strconv.Itoa(size)
+		}
+	}
+
+	return frontPath + "-" + thumbnailSize
+}
+
+// Bits per pixel and palette size of an image's colour model
+func colorDepth(model color.Model) (int, int) {
+	if palette, ok := model.(color.Palette); ok {
+		return 8, len(palette)
+	}
+
+	switch model {
+	case color.GrayModel:
+		return 8, 0
+	case color.Gray16Model:
+		return 16, 0
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 64, 0
+	case color.CMYKModel, color.RGBAModel, color.NRGBAModel:
+		return 32, 0
+	default:
+		// JPEG's YCbCr
+		return 24, 0
+	}
+}
+
+// Converts the image to the format when it isn't already in it and reads its dimensions
+func newCoverArt(data []byte, format string) (*CoverArt, error) {
+	config, decodedFormat, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to read cover image: %s", err)
+		return nil, errors.New(errorMessage)
+	}
+
+	if decodedFormat != format {
+		decoded, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			errorMessage := fmt.Sprintf("Failed to decode %s cover image: %s", decodedFormat, err)
+			return nil, errors.New(errorMessage)
+		}
+
+		var converted bytes.Buffer
+		if format == "png" {
+			err = png.Encode(&converted, decoded)
+		} else {
+			err = jpeg.Encode(&converted, decoded, &jpeg.Options{Quality: 90})
+		}
+		if err != nil {
+			errorMessage := fmt.Sprintf("Failed to convert cover image to %s: %s", format, err)
+			return nil, errors.New(errorMessage)
+		}
+
+		return newCoverArt(converted.Bytes(), format)
+	}
+
+	depth, colors := colorDepth(config.ColorModel)
+	return &CoverArt{
+		Data:     data,
+		MIMEType: "image/" + format,
+		Width:    config.Width,
+		Height:   config.Height,
+		Depth:    depth,
+		Colors:   colors,
+	}, nil
+}
+
+// Downloads the release's front cover from the Cover Art Archive. Returns nil without an error when the release has
+// no front cover.
+func fetchCoverArt(releaseID string, logger maokai.Logger) (*CoverArt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), COVER_ART_TIMEOUT)
+	defer cancel()
+
+	requestURL := COVER_ART_URL + frontCoverPath(releaseID, COVER_ART_MAX_SIZE)
+	logger.CreateLogf("Requesting %s", requestURL)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error creating request: %s", err)
+		return nil, errors.New(errorMessage)
+	}
+	req.Header.Set("User-Agent", USER_AGENT)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error making request: %s", err)
+		return nil, errors.New(errorMessage)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		errorMessage := fmt.Sprintf("Cover Art Archive request %s failed: %s", requestURL, resp.Status)
+		return nil, errors.New(errorMessage)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error reading body: %s", err)
+		return nil, errors.New(errorMessage)
+	}
+
+	return newCoverArt(data, COVER_ART_FORMAT)
+}
+
+// Builds the FLAC PICTURE block embedding the cover as the front cover
+func (cover *CoverArt) MetaDataBlock() flac.MetaDataBlock {
+	var buffer bytes.Buffer
+	writeUint32 := func(value int) {
+		binary.Write(&buffer, binary.BigEndian, uint32(value))
+	}
+
+	writeUint32(FLAC_PICTURE_FRONT_COVER)
+	writeUint32(len(cover.MIMEType))
+	buffer.WriteString(cover.MIMEType)
+	// No description
+	writeUint32(0)
+	writeUint32(cover.Width)
+	writeUint32(cover.Height)
+	writeUint32(cover.Depth)
+	writeUint32(cover.Colors)
+	writeUint32(len(cover.Data))
+	buffer.Write(cover.Data)
+
+	return flac.MetaDataBlock{Type: flac.Picture, Data: buffer.Bytes()}
+}
+
+// Reads a PICTURE block back into a cover
+func parseFlacPicture(data []byte) (*CoverArt, error) {
+	reader := bytes.NewReader(data)
+	readUint32 := func() int {
+		var value uint32
+		binary.Read(reader, binary.BigEndian, &value)
+		return int(value)
+	}
+	readBytes := func(length int) ([]byte, error) {
+		if length > reader.Len() {
+			return nil, errors.New("PICTURE block is truncated")
+		}
+		value := make([]byte, length)
+		_, err := io.ReadFull(reader, value)
+		return value, err
+	}
+
+	cover := CoverArt{}
+	readUint32()
+	MIMEType, err := readBytes(readUint32())
+	if err != nil {
+		return nil, err
+	}
+	cover.MIMEType = string(MIMEType)
+
+	if _, err := readBytes(readUint32()); err != nil {
+		return nil, err
+	}
+
+	cover.Width = readUint32()
+	cover.Height = readUint32()
+	cover.Depth = readUint32()
+	cover.Colors = readUint32()
+
+	cover.Data, err = readBytes(readUint32())
+	if err != nil {
+		return nil, err
+	}
+
+	return &cover, nil
+}
+
+// Returns the first front cover embedded in the file, nil when it has none
+func findFlacCover(flacFile *flac.File) (*CoverArt, error) {
+	for _, block := range flacFile.Meta {
+		if block.Type != flac.Picture || len(block.Data) < 4 {
+			continue
+		}
+
+		if binary.BigEndian.Uint32(block.Data) == FLAC_PICTURE_FRONT_COVER {
+			return parseFlacPicture(block.Data)
+		}
+	}
+
+	return nil, nil
+}
+
+// Replaces the file's PICTURE blocks with the cover
+func setFlacCover(flacFile *flac.File, cover *CoverArt) {
+	if cover == nil {
+		return
+	}
+
+	meta := []*flac.MetaDataBlock{}
+	for _, block := range flacFile.Meta {
+		if block.Type != flac.Picture {
+			meta = append(meta, block)
+		}
+	}
+
+	pictureMeta := cover.MetaDataBlock()
+	flacFile.Meta = append(meta, &pictureMeta)
+}
+
+// Fetches the release's front cover and saves it in the album folder, returning it to be embedded. Returns nil when
+// the release has no cover or it can't be fetched, which doesn't stop the rip.
+func saveCoverArt(release ReleaseInfo, pathToAlbum string, logger maokai.Logger) *CoverArt {
+	// Releases from other sources have no MBID to look the cover up by
+	if release.ID == "" {
+		return nil
+	}
+
+	cover, err := fetchCoverArt(release.ID, logger)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to fetch cover art for %s: %s", release.ID, err)
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+		return nil
+	}
+	if cover == nil {
+		message := fmt.Sprintf("The Cover Art Archive has no front cover for %s", release.ID)
+		log.Println(message)
+		logger.CreateLog(message)
+		return nil
+	}
+
+	coverPath := path.Join(pathToAlbum, coverArtFileName())
+	logger.CreateLogf("Saving %dx%d cover to %s", cover.Width, cover.Height, coverPath)
+	if err := os.WriteFile(coverPath, cover.Data, 0644); err != nil {
+		errorMessage := fmt.Sprintf("Failed to save cover %s: %s", coverPath, err)
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+	}
+
+	return cover
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testReleaseID = "b84ee12a-09ef-421b-82de-0441a926375b"
+
+// Points the cover art settings at the server until the test ends
+func useCoverArtServer(t *testing.T, serverURL string, maxSize int, format string) {
+	t.Helper()
+
+	url, size, coverFormat := COVER_ART_URL, COVER_ART_MAX_SIZE, COVER_ART_FORMAT
+	t.Cleanup(func() {
+		COVER_ART_URL, COVER_ART_MAX_SIZE, COVER_ART_FORMAT = url, size, coverFormat
+	})
+	COVER_ART_URL, COVER_ART_MAX_SIZE, COVER_ART_FORMAT = serverURL, maxSize, format
+}
+
+func testPNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		img.Set(x, 0, color.NRGBA{R: 200, A: 255})
+	}
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func TestFetchCoverArt(t *testing.T) {
+	cover := testPNG(t, 40, 30)
+	requested := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(cover)
+	}))
+	defer server.Close()
+	useCoverArtServer(t, server.URL, 800, "jpeg")
+
+	coverArt, err := fetchCoverArt(testReleaseID, discardLogger{})
+	if err != nil {
+		t.Fatalf("fetchCoverArt: %v", err)
+	}
+
+	// The biggest thumbnail that fits in 800 pixels
+	if want := "/release/" + testReleaseID + "/front-500"; requested != want {
+		t.Errorf("requested %s, want %s", requested, want)
+	}
+	if coverArt.MIMEType != "image/jpeg" || coverArt.Width != 40 || coverArt.Height != 30 {
+		t.Errorf("fetchCoverArt = %s %dx%d, want image/jpeg 40x30", coverArt.MIMEType, coverArt.Width, coverArt.Height)
+	}
+
+	block := coverArt.MetaDataBlock()
+	parsed, err := parseFlacPicture(block.Data)
+	if err != nil {
+		t.Fatalf("parseFlacPicture: %v", err)
+	}
+	if parsed.MIMEType != coverArt.MIMEType || !bytes.Equal(parsed.Data, coverArt.Data) || parsed.Width != 40 {
+		t.Errorf("PICTURE block round trip gave %s %dx%d", parsed.MIMEType, parsed.Width, parsed.Height)
+	}
+}
+
+func TestFetchCoverArtMissing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	useCoverArtServer(t, server.URL, 0, "jpeg")
+
+	coverArt, err := fetchCoverArt(testReleaseID, discardLogger{})
+	if err != nil || coverArt != nil {
+		t.Errorf("fetchCoverArt of a release without a cover = %v, %v, want nil, nil", coverArt, err)
+	}
+}
+
+func TestFetchCoverArtServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	}))
+	defer server.Close()
+	useCoverArtServer(t, server.URL, 0, "png")
+
+	if _, err := fetchCoverArt(testReleaseID, discardLogger{}); err == nil {
+		t.Error("fetchCoverArt of a failing server didn't fail")
+	}
+}
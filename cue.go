@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// How the audio a cue sheet describes is split into files
+type CueLayout int
+
+const (
+	// One file per track running from its INDEX 01 to the next track's INDEX 01, so each pregap sits at the end of
+	// the previous track's file. This is EAC's "noncompliant" layout and what cdparanoia's batch mode writes.
+	CueLayoutPerTrack CueLayout = iota
+	// A single file holding the disc from INDEX 01 of the first track to the lead-out
+	CueLayoutImage
+)
+
+type CueTrack struct {
+	Number    int
+	Title     string
+	Performer string
+	ISRC      string
+	// File holding the track in the per track layout
+	FileName string
+	// Sectors between INDEX 00 and INDEX 01
+	Pregap int
+	// Positions of INDEX 02 onwards in sectors after INDEX 01
+	Indexes []int
+}
+
+type CueSheet struct {
+	Catalog   string
+	Performer string
+	Title     string
+	Date      string
+	DiscID    string
+	TOC       DiscTOC
+	Tracks    []CueTrack
+	// File holding the audio before track 1 when a hidden track was ripped, empty otherwise
+	HiddenTrackFileName string
+	// File holding the whole disc in the image layout
+	ImageFileName string
+}
+
+// Formats sectors as the MM:SS:FF cue sheets use
+func formatCueTime(sectors int) string {
+	frames := sectors % SECTORS_PER_SECOND
+	seconds := sectors / SECTORS_PER_SECOND
+
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/60, seconds%60, frames)
+}
+
+// Quotes a cue sheet value, cue sheets have no way to escape quotes
+func cueQuote(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, "'") + `"`
+}
+
+// Builds the cue sheet from the TOC, the songs' tags and the scanned index points
+func buildCueSheet(disc DiscInfo, release ReleaseInfo, songs []FlacTags, fileNames map[int]string, scan TrackIndexScan) CueSheet {
+	sheet := CueSheet{
+		Catalog:   scan.Catalog,
+		Performer: artistCreditString(release.Artists),
+		Title:     release.Title,
+		DiscID:    disc.ID,
+		TOC:       disc.TOC,
+	}
+
+	// cdrdao doesn't always find the codes the drive reported when the disc ID was read
+	if sheet.Catalog == "" {
+		sheet.Catalog = disc.MCN
+	}
+
+	songsByTrack := map[int]FlacTags{}
+	for _, song := range songs {
+		songsByTrack[int(song.TrackNumber)] = song
+	}
+
+	for trackNumber := disc.TOC.FirstTrack; trackNumber <= disc.TOC.LastTrack; trackNumber++ {
+		info := scan.Tracks[trackNumber]
+		track := CueTrack{
+			Number:   trackNumber,
+			ISRC:     info.ISRC,
+			FileName: fileNames[trackNumber],
+			Pregap:   info.Pregap,
+			Indexes:  info.Indexes,
+		}
+		if track.ISRC == "" {
+			track.ISRC = disc.ISRCs[trackNumber]
+		}
+
+		// The TOC is more reliable than the subchannel for the first track's pregap
+		if trackNumber == disc.TOC.FirstTrack {
+			track.Pregap = firstTrackPregap(disc.TOC)
+		}
+
+		if song, found := songsByTrack[trackNumber]; found {
+			track.Title = song.Title
+			track.Performer = strings.Join(song.Artist, song.JoinPhrase)
+			if sheet.Date == "" {
+				sheet.Date = song.ReleaseDate
+			}
+		}
+
+		sheet.Tracks = append(sheet.Tracks, track)
+	}
+
+	if hiddenSong, found := songsByTrack[0]; found {
+		sheet.HiddenTrackFileName = fileNames[int(hiddenSong.TrackNumber)]
+	}
+
+	return sheet
+}
+
+func (sheet CueSheet) Render(layout CueLayout) string {
+	var builder strings.Builder
+	line := func(format string, a ...any) {
+		fmt.Fprintf(&builder, format+"\r\n", a...)
+	}
+
+	if sheet.Date != "" {
+		line("REM DATE %s", sheet.Date)
+	}
+	line("REM DISCID %08X", computeAccurateRipDiscID(sheet.TOC).CDDBID)
+	line("REM MUSICBRAINZ_DISCID %s", sheet.DiscID)
+	line(`REM COMMENT "sona"`)
+	if sheet.Catalog != "" {
+		line("CATALOG %s", sheet.Catalog)
+	}
+	line("PERFORMER %s", cueQuote(sheet.Performer))
+	line("TITLE %s", cueQuote(sheet.Title))
+
+	trackHeader := func(track CueTrack) {
+		line("  TRACK %02d AUDIO", track.Number)
+		if track.Title != "" {
+			line("    TITLE %s", cueQuote(track.Title))
+		}
+		if track.Performer != "" {
+			line("    PERFORMER %s", cueQuote(track.Performer))
+		}
+		if track.ISRC != "" {
+			line("    ISRC %s", track.ISRC)
+		}
+	}
+
+	extraIndexes := func(track CueTrack, indexOne int) {
+		for i, position := range track.Indexes {
+			line("    INDEX %02d %s", i+2, formatCueTime(indexOne+position))
+		}
+	}
+
+	if layout == CueLayoutImage {
+		// The image starts at INDEX 01 of the first track
+		imageStart := sheet.TOC.Offsets[0]
+		for i, track := range sheet.Tracks {
+			indexOne := sheet.TOC.TrackOffset(track.Number) - imageStart
+			switch {
+			case i == 0 && sheet.HiddenTrackFileName != "":
+				// The hidden track file holds track 1's pregap
+				line("FILE %s WAVE", cueQuote(sheet.HiddenTrackFileName))
+				trackHeader(track)
+				line("    INDEX 00 %s", formatCueTime(0))
+				line("FILE %s WAVE", cueQuote(sheet.ImageFileName))
+			case i == 0:
+				line("FILE %s WAVE", cueQuote(sheet.ImageFileName))
+				trackHeader(track)
+				if track.Pregap > 0 {
+					line("    PREGAP %s", formatCueTime(track.Pregap))
+				}
+			default:
+				trackHeader(track)
+			}
+
+			if i > 0 && track.Pregap > 0 {
+				line("    INDEX 00 %s", formatCueTime(indexOne-track.Pregap))
+			}
+			line("    INDEX 01 %s", formatCueTime(indexOne))
+			extraIndexes(track, indexOne)
+		}
+
+		return builder.String()
+	}
+
+	for i, track := range sheet.Tracks {
+		switch {
+		case i == 0 && sheet.HiddenTrackFileName != "":
+			// The hidden track file holds track 1's pregap
+			line("FILE %s WAVE", cueQuote(sheet.HiddenTrackFileName))
+			trackHeader(track)
+			line("    INDEX 00 %s", formatCueTime(0))
+			line("FILE %s WAVE", cueQuote(track.FileName))
+		case i == 0:
+			line("FILE %s WAVE", cueQuote(track.FileName))
+			trackHeader(track)
+			if track.Pregap > 0 {
+				line("    PREGAP %s", formatCueTime(track.Pregap))
+			}
+		case track.Pregap > 0:
+			// The pregap is at the end of the previous track's file
+			previousLength := sheet.TOC.TrackSectors(sheet.Tracks[i-1].Number)
+			trackHeader(track)
+			line("    INDEX 00 %s", formatCueTime(previousLength-track.Pregap))
+			line("FILE %s WAVE", cueQuote(track.FileName))
+		default:
+			line("FILE %s WAVE", cueQuote(track.FileName))
+			trackHeader(track)
+		}
+
+		line("    INDEX 01 %s", formatCueTime(0))
+		extraIndexes(track, 0)
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/mikogd/maokai"
+	"go.uploadedlobster.com/discid"
+)
+
+const (
+	// Sectors read per second of audio
+	SECTORS_PER_SECOND = 75
+	// Sectors before the first track that are not part of the TOC offsets
+	LEAD_IN_SECTORS = 150
+)
+
+// Table of contents of an audio CD. Offsets are in sectors and include the 150 sector lead-in, the same as
+// discid's TOC string.
+type DiscTOC struct {
+	FirstTrack int
+	LastTrack  int
+	LeadOut    int
+	// Offset of each track from FirstTrack to LastTrack
+	Offsets []int
+}
+
+// Parses a TOC string in the "first last leadout offset1 offset2 ..." format returned by discid
+func ParseTOCString(TOCString string) (DiscTOC, error) {
+	fields := strings.Fields(TOCString)
+	if len(fields) < 4 {
+		errorMessage := fmt.Sprintf("TOC \"%s\" has less than 4 fields", TOCString)
+		return DiscTOC{}, errors.New(errorMessage)
+	}
+
+	values := make([]int, len(fields))
+	for i, field := range fields {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Failed to parse TOC field \"%s\": %s", field, err)
+			return DiscTOC{}, errors.New(errorMessage)
+		}
+		values[i] = value
+	}
+
+	toc := DiscTOC{
+		FirstTrack: values[0],
+		LastTrack:  values[1],
+		LeadOut:    values[2],
+		Offsets:    values[3:],
+	}
+
+	if len(toc.Offsets) != toc.TrackCount() {
+		errorMessage := fmt.Sprintf("TOC \"%s\" has %d offsets for %d tracks", TOCString, len(toc.Offsets), toc.TrackCount())
+		return DiscTOC{}, errors.New(errorMessage)
+	}
+
+	return toc, nil
+}
+
+func (toc DiscTOC) TrackCount() int {
+	return toc.LastTrack - toc.FirstTrack + 1
+}
+
+// Offset of the track in sectors including the lead-in
+func (toc DiscTOC) TrackOffset(trackNumber int) int {
+	return toc.Offsets[trackNumber-toc.FirstTrack]
+}
+
+// Length of the track in sectors, running up to the start of the next track or the lead-out
+func (toc DiscTOC) TrackSectors(trackNumber int) int {
+	if trackNumber == toc.LastTrack {
+		return toc.LeadOut - toc.TrackOffset(trackNumber)
+	}
+
+	return toc.TrackOffset(trackNumber+1) - toc.TrackOffset(trackNumber)
+}
+
+// Track holding the sector, counted without the lead-in like cdparanoia's positions. Returns 0 for sectors before the
+// first track or after the lead-out.
+func (toc DiscTOC) TrackAtSector(sector int) int {
+	for trackNumber := toc.LastTrack; trackNumber >= toc.FirstTrack; trackNumber-- {
+		if sector >= toc.TrackOffset(trackNumber)-LEAD_IN_SECTORS {
+			if sector >= toc.LeadOut-LEAD_IN_SECTORS {
+				return 0
+			}
+			return trackNumber
+		}
+	}
+
+	return 0
+}
+
+// The disc read from the drive
+type DiscInfo struct {
+	Device    string
+	ID        string
+	TOCString string
+	TOC       DiscTOC
+	// Media catalog number, the disc's UPC/EAN barcode, empty when the disc doesn't have one
+	MCN string
+	// ISRCs read from the subchannel keyed by track number, tracks without one are left out
+	ISRCs map[int]string
+}
+
+// Reads the disc ID, TOC, MCN and ISRCs of the disc in the drive
+func ReadDiscInfo(device string, logger maokai.Logger) (DiscInfo, error) {
+	disc, err := discid.ReadFeatures(device, discid.FeatureRead|discid.FeatureMCN|discid.FeatureISRC)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to read disc ID: %s\n", err)
+		return DiscInfo{}, errors.New(errorMessage)
+	}
+
+	defer disc.Close()
+
+	info := DiscInfo{
+		Device:    device,
+		ID:        disc.ID(),
+		TOCString: disc.TOCString(),
+		MCN:       normalizeDiscCode(disc.MCN()),
+		ISRCs:     map[int]string{},
+	}
+
+	log.Printf("Disc ID: %s\n", info.ID)
+	logger.CreateLogf("Disc ID: %s", info.ID)
+	logger.CreateLogf("Disc TOC: %s", info.TOCString)
+
+	info.TOC, err = ParseTOCString(info.TOCString)
+	if err != nil {
+		return DiscInfo{}, err
+	}
+
+	if info.MCN != "" {
+		logger.CreateLogf("Disc MCN: %s", info.MCN)
+	}
+
+	for trackNumber := info.TOC.FirstTrack; trackNumber <= info.TOC.LastTrack; trackNumber++ {
+		if ISRC := normalizeDiscCode(disc.Track(trackNumber).ISRC); ISRC != "" {
+			info.ISRCs[trackNumber] = ISRC
+			logger.CreateLogf("Track %02d ISRC: %s", trackNumber, ISRC)
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/mikogd/maokai"
+)
+
+// Cleans up an MCN or ISRC read from the disc. Drives without the code report it as all zeros.
+func normalizeDiscCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if strings.Trim(code, "0") == "" {
+		return ""
+	}
+
+	return code
+}
+
+// Whether the disc's MCN is the release's barcode. MCNs are 13 digit EANs while MusicBrainz stores 12 digit UPCs
+// without the leading zero.
+func barcodeMatchesMCN(barcode string, MCN string) bool {
+	barcode = strings.TrimLeft(strings.TrimSpace(barcode), "0")
+	return barcode != "" && barcode == strings.TrimLeft(MCN, "0")
+}
+
+// Counts the disc's MCN and ISRCs that MusicBrainz lists for the release, used to pick between releases sharing a
+// disc ID
+func countDiscCodeMatches(release ReleaseInfo, disc DiscInfo) int {
+	matches := 0
+	if disc.MCN != "" && barcodeMatchesMCN(release.Barcode, disc.MCN) {
+		matches++
+	}
+
+	releaseISRCs := map[string]bool{}
+	for _, medium := range release.Media {
+		for _, track := range medium.Tracks {
+			for _, ISRC := range track.ISRCs {
+				releaseISRCs[ISRC] = true
+			}
+		}
+	}
+
+	for _, ISRC := range disc.ISRCs {
+		if releaseISRCs[ISRC] {
+			matches++
+		}
+	}
+
+	return matches
+}
+
+// Tags the songs with the disc ID and the ISRCs and MCN read from the disc, which are more reliable than MusicBrainz's
+// for the copy in the drive, and warns where they disagree with the release
+func applyDiscCodes(songs []FlacTags, disc DiscInfo, release ReleaseInfo, discNumber uint8, logger maokai.Logger) {
+	warn := func(message string) {
+		log.Printf("Warning: %s\n", message)
+		logger.CreateErrorLog(message)
+	}
+
+	if disc.MCN != "" && release.Barcode != "" && !barcodeMatchesMCN(release.Barcode, disc.MCN) {
+		warn(fmt.Sprintf("Disc MCN %s doesn't match barcode %s of release %s", disc.MCN, release.Barcode, release.ID))
+	}
+
+	ISRCsByTrack := map[uint8][]string{}
+	if medium, found := releaseMedium(release, discNumber); found {
+		for _, track := range medium.Tracks {
+			var trackNumber uint8
+			fmt.Sscan(track.Number, &trackNumber)
+			ISRCsByTrack[trackNumber] = track.ISRCs
+		}
+	}
+
+	for i, song := range songs {
+		songs[i].DiscID = disc.ID
+		if disc.MCN != "" {
+			songs[i].Barcode = disc.MCN
+		}
+
+		ISRC, found := disc.ISRCs[int(song.TrackNumber)]
+		if !found {
+			continue
+		}
+		songs[i].ISRC = ISRC
+
+		listedISRCs := ISRCsByTrack[song.TrackNumber]
+		if len(listedISRCs) > 0 && !slices.Contains(listedISRCs, ISRC) {
+			warn(fmt.Sprintf("Track %02d ISRC %s isn't one MusicBrainz lists for the recording: %s",
+				song.TrackNumber, ISRC, strings.Join(listedISRCs, ", ")))
+		}
+	}
+}
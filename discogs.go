@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mikogd/maokai"
+)
+
+// Set with DISCOGS_URL
+var DISCOGS_URL = "https://api.discogs.com"
+
+// Personal access token, Discogs only allows searching with one. Set with DISCOGS_TOKEN.
+var DISCOGS_TOKEN = ""
+
+// Most search results looked up in full
+const DISCOGS_MAX_RESULTS = 5
+
+// Looks discs up on Discogs by the barcode in the disc's MCN. Discogs has no disc IDs so discs without an MCN can't be
+// found.
+type DiscogsProvider struct {
+	URL    string
+	Token  string
+	Logger maokai.Logger
+}
+
+func NewDiscogsProvider(logger maokai.Logger) DiscogsProvider {
+	return DiscogsProvider{URL: strings.TrimSuffix(DISCOGS_URL, "/"), Token: DISCOGS_TOKEN, Logger: logger}
+}
+
+func loadDiscogsConfig() {
+	if discogsURL := os.Getenv("DISCOGS_URL"); discogsURL != "" {
+		DISCOGS_URL = discogsURL
+	}
+	DISCOGS_TOKEN = os.Getenv("DISCOGS_TOKEN")
+}
+
+func (provider DiscogsProvider) Name() string {
+	return SOURCE_DISCOGS
+}
+
+type discogsSearchResponse struct {
+	Results []struct {
+		ID int `json:"id"`
+	} `json:"results"`
+}
+
+type discogsArtist struct {
+	Name string `json:"name"`
+	// Name the artist is credited as on the release
+	ANV  string `json:"anv"`
+	Join string `json:"join"`
+}
+
+type discogsTrack struct {
+	Position string          `json:"position"`
+	Type     string          `json:"type_"`
+	Title    string          `json:"title"`
+	Duration string          `json:"duration"`
+	Artists  []discogsArtist `json:"artists"`
+}
+
+type discogsRelease struct {
+	ID       int             `json:"id"`
+	Title    string          `json:"title"`
+	Released string          `json:"released"`
+	Country  string          `json:"country"`
+	Artists  []discogsArtist `json:"artists"`
+	Labels   []struct {
+		Name          string `json:"name"`
+		CatalogNumber string `json:"catno"`
+	} `json:"labels"`
+	Formats []struct {
+		Name string `json:"name"`
+	} `json:"formats"`
+	Genres      []string `json:"genres"`
+	Styles      []string `json:"styles"`
+	Identifiers []struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	} `json:"identifiers"`
+	Tracklist []discogsTrack `json:"tracklist"`
+}
+
+// Discogs tells artists sharing a name apart with a number e.g. "Nirvana (2)"
+var discogsArtistNumberPattern = regexp.MustCompile(` \(\d+\)$`)
+
+// Discogs positions of CD tracks on multi-disc releases e.g. 2-05, CD2-05 or 2.05
+var discogsPositionPattern = regexp.MustCompile(`^(?:CD|Disc\s*)?(\d+)[-.](\d+)$`)
+
+func discogsArtistCredit(artists []discogsArtist) []CreditedArtist {
+	credit := []CreditedArtist{}
+	for _, artist := range artists {
+		name := artist.ANV
+		if name == "" {
+			name = discogsArtistNumberPattern.ReplaceAllString(artist.Name, "")
+		}
+
+		joinPhrase := ""
+		if artist.Join != "" {
+			joinPhrase = " " + strings.TrimSpace(artist.Join) + " "
+			if artist.Join == "," {
+				joinPhrase = ", "
+			}
+		}
+
+		credit = append(credit, CreditedArtist{Name: name, JoinPhrase: joinPhrase})
+	}
+
+	return credit
+}
+
+// Parses a Discogs duration such as 3:45 or 1:02:03 into milliseconds, 0 when it is missing
+func parseDiscogsDuration(duration string) uint32 {
+	seconds := 0
+	for _, part := range strings.Split(duration, ":") {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + value
+	}
+
+	return uint32(seconds * 1000)
+}
+
+// Builds a release from a Discogs release, splitting its tracklist into media by the track positions
+func releaseFromDiscogs(discogs discogsRelease) ReleaseInfo {
+	release := ReleaseInfo{
+		Title:   discogs.Title,
+		Date:    strings.TrimSuffix(strings.TrimSuffix(discogs.Released, "-00"), "-00"),
+		Country: discogs.Country,
+		Artists: discogsArtistCredit(discogs.Artists),
+	}
+
+	// Every release should credit someone, but Discogs doesn't insist on it
+	if len(release.Artists) == 0 {
+		release.Artists = []CreditedArtist{{Name: UNKNOWN_ARTIST}}
+	}
+
+	if len(discogs.Labels) > 0 {
+		release.Label = discogsArtistNumberPattern.ReplaceAllString(discogs.Labels[0].Name, "")
+		release.CatalogNumber = discogs.Labels[0].CatalogNumber
+	}
+
+	for _, identifier := range discogs.Identifiers {
+		if identifier.Type == "Barcode" && release.Barcode == "" {
+			release.Barcode = strings.ReplaceAll(identifier.Value, " ", "")
+		}
+	}
+
+	release.Genres = append(release.Genres, discogs.Genres...)
+	release.Genres = append(release.Genres, discogs.Styles...)
+
+	format := ""
+	if len(discogs.Formats) > 0 {
+		format = discogs.Formats[0].Name
+	}
+
+	mediaByPosition := map[uint8]*MediumInfo{}
+	positions := []uint8{}
+	for _, discogsTrack := range discogs.Tracklist {
+		// Headings and the like aren't tracks
+		if discogsTrack.Type != "" && discogsTrack.Type != "track" && discogsTrack.Type != "index" {
+			continue
+		}
+
+		mediumPosition, trackPosition := 1, 0
+		if match := discogsPositionPattern.FindStringSubmatch(discogsTrack.Position); match != nil {
+			mediumPosition, _ = strconv.Atoi(match[1])
+			trackPosition, _ = strconv.Atoi(match[2])
+		}
+
+		medium, found := mediaByPosition[uint8(mediumPosition)]
+		if !found {
+			medium = &MediumInfo{Position: uint8(mediumPosition), Format: format}
+			mediaByPosition[uint8(mediumPosition)] = medium
+			positions = append(positions, uint8(mediumPosition))
+		}
+
+		if trackPosition == 0 {
+			trackPosition = len(medium.Tracks) + 1
+		}
+
+		credit := release.Artists
+		if len(discogsTrack.Artists) > 0 {
+			credit = discogsArtistCredit(discogsTrack.Artists)
+		}
+
+		medium.Tracks = append(medium.Tracks, TrackInfo{
+			Position: uint8(trackPosition),
+			Number:   strconv.Itoa(trackPosition),
+			Title:    discogsTrack.Title,
+			Length:   parseDiscogsDuration(discogsTrack.Duration),
+			Artists:  credit,
+		})
+	}
+
+	for _, position := range positions {
+		release.Media = append(release.Media, *mediaByPosition[position])
+	}
+
+	return release
+}
+
+func (provider DiscogsProvider) get(ctx context.Context, path string, query url.Values, result any) error {
+	requestURL := provider.URL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	headers := map[string]string{"Authorization": "Discogs token=" + provider.Token}
+	body, err := providerGet(ctx, requestURL, headers, provider.Logger)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, result); err != nil {
+		errorMessage := fmt.Sprintf("Error parsing json: %s", err)
+		return errors.New(errorMessage)
+	}
+
+	return nil
+}
+
+func (provider DiscogsProvider) Lookup(ctx context.Context, disc DiscInfo) ([]ReleaseCandidate, error) {
+	if provider.Token == "" {
+		return nil, errors.New("DISCOGS_TOKEN isn't set, Discogs can't be searched")
+	}
+	if disc.MCN == "" {
+		provider.Logger.CreateLog("Disc has no MCN to search Discogs by")
+		return nil, ErrNoRelease
+	}
+
+	query := url.Values{}
+	query.Set("barcode", strings.TrimLeft(disc.MCN, "0"))
+	query.Set("type", "release")
+	query.Set("format", "CD")
+
+	search := discogsSearchResponse{}
+	if err := provider.get(ctx, "/database/search", query, &search); err != nil {
+		return nil, err
+	}
+
+	releases := []ReleaseInfo{}
+	sourceIDs := []string{}
+	for i, result := range search.Results {
+		if i >= DISCOGS_MAX_RESULTS {
+			break
+		}
+
+		discogs := discogsRelease{}
+		if err := provider.get(ctx, fmt.Sprintf("/releases/%d", result.ID), nil, &discogs); err != nil {
+			return nil, err
+		}
+
+		releases = append(releases, releaseFromDiscogs(discogs))
+		sourceIDs = append(sourceIDs, strconv.Itoa(discogs.ID))
+	}
+
+	if len(releases) == 0 {
+		return nil, ErrNoRelease
+	}
+
+	candidates := scoreReleases(SOURCE_DISCOGS, releases, disc, RELEASE_PREFERENCES)
+	for i := range candidates {
+		candidates[i].SourceID = sourceIDs[i]
+	}
+	sortReleaseCandidates(candidates)
+
+	return candidates, nil
+}
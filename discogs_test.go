@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestReleaseFromDiscogsWithoutArtists(t *testing.T) {
+	release := releaseFromDiscogs(discogsRelease{
+		Title: "Album",
+		Tracklist: []discogsTrack{
+			{Position: "1", Title: "First", Duration: "3:00"},
+			{Position: "2", Title: "Second", Duration: "4:00"},
+		},
+	})
+
+	if name := releaseArtistName(release); name != UNKNOWN_ARTIST {
+		t.Errorf("releaseArtistName = %q, want %q", name, UNKNOWN_ARTIST)
+	}
+
+	songs, err := GetFlacTags(release, 1, discardLogger{})
+	if err != nil {
+		t.Fatalf("GetFlacTags: %v", err)
+	}
+	if len(songs) != 2 {
+		t.Fatalf("GetFlacTags returned %d songs, want 2", len(songs))
+	}
+	for _, song := range songs {
+		if !slices.Equal(song.Artist, []string{UNKNOWN_ARTIST}) || !slices.Equal(song.AlbumArtist, []string{UNKNOWN_ARTIST}) {
+			t.Errorf("Track %d credits %v by %v, want %s", song.TrackNumber, song.Artist, song.AlbumArtist, UNKNOWN_ARTIST)
+		}
+	}
+}
+
+func TestGetFlacTagsWithoutArtists(t *testing.T) {
+	release := ReleaseInfo{
+		Title: "Album",
+		Media: []MediumInfo{{Position: 1, Tracks: []TrackInfo{{Number: "1", Title: "First"}}}},
+	}
+
+	songs, err := GetFlacTags(release, 1, discardLogger{})
+	if err != nil {
+		t.Fatalf("GetFlacTags: %v", err)
+	}
+	if len(songs) != 1 || len(songs[0].Artist) != 0 || songs[0].ArtistType != "" {
+		t.Errorf("GetFlacTags returned %+v, want one song crediting no one", songs)
+	}
+	if name := releaseArtistName(release); name != UNKNOWN_ARTIST {
+		t.Errorf("releaseArtistName = %q, want %q", name, UNKNOWN_ARTIST)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mikogd/maokai"
+)
+
+// Information about an optical drive as reported by udev
+type DriveInfo struct {
+	Device       string
+	Vendor       string
+	Model        string
+	IsCDROM      bool
+	Capabilities []string
+	MediaPresent bool
+	Properties   map[string]string
+}
+
+// Whether the drive can read CDs, optical drives that only read DVDs or Blu-rays don't report the CD capability
+func (info DriveInfo) CanReadCD() bool {
+	return info.IsCDROM && slices.Contains(info.Capabilities, "CD")
+}
+
+func getDriveInfo(dev string) (DriveInfo, error) {
+	out, err := getDevInfo(dev)
+	if err != nil {
+		return DriveInfo{}, err
+	}
+
+	properties := parseDevInfo(out)
+
+	info := DriveInfo{
+		Device:       dev,
+		Vendor:       properties["ID_VENDOR"],
+		Model:        properties["ID_MODEL"],
+		IsCDROM:      properties["ID_CDROM"] == "1",
+		MediaPresent: properties["ID_CDROM_MEDIA"] == "1",
+		Properties:   properties,
+	}
+
+	// Capability flags look like ID_CDROM_CD_R=1, the ID_CDROM_MEDIA_* keys describe the inserted disc instead
+	for key, value := range properties {
+		if !strings.HasPrefix(key, "ID_CDROM_") || strings.HasPrefix(key, "ID_CDROM_MEDIA") {
+			continue
+		}
+
+		if value == "1" {
+			info.Capabilities = append(info.Capabilities, strings.TrimPrefix(key, "ID_CDROM_"))
+		}
+	}
+	sort.Strings(info.Capabilities)
+
+	return info, nil
+}
+
+// Lists every /dev/sr* device along with its udev properties
+func listDrives(logger maokai.Logger) ([]DriveInfo, error) {
+	logger.CreateLog("Listing all optical drives")
+	matches, err := filepath.Glob("/dev/sr*")
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error listing /dev/sr*: %v", err)
+		return nil, errors.New(errorMessage)
+	}
+
+	drives := make([]DriveInfo, 0, len(matches))
+	for _, dev := range matches {
+		info, err := getDriveInfo(dev)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Failed to get drive info for %s\n%v", dev, err)
+			return nil, errors.New(errorMessage)
+		}
+
+		drives = append(drives, info)
+	}
+
+	return drives, nil
+}
+
+// Returns the drive to rip from. When device is empty the first CD drive found is used, otherwise the device is
+// checked to be a CD drive.
+func resolveCDDrive(device string, logger maokai.Logger) (string, error) {
+	if device == "" {
+		return getCDDriveDeviceName(logger)
+	}
+
+	info, err := getDriveInfo(device)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to check device %s\n%v", device, err)
+		return "", errors.New(errorMessage)
+	}
+
+	if !info.CanReadCD() {
+		return "", fmt.Errorf("%s is not a CD drive", device)
+	}
+
+	logger.CreateLogf("Using CD drive %s", device)
+
+	return device, nil
+}
+
+func printDrive(info DriveInfo) {
+	fmt.Printf("%s\n", info.Device)
+	fmt.Printf("  Vendor:        %s\n", info.Vendor)
+	fmt.Printf("  Model:         %s\n", info.Model)
+	fmt.Printf("  CD drive:      %t\n", info.CanReadCD())
+	fmt.Printf("  Capabilities:  %s\n", strings.Join(info.Capabilities, ", "))
+
+	if !info.MediaPresent {
+		fmt.Printf("  Media present: false\n")
+		return
+	}
+
+	fmt.Printf("  Media present: true\n")
+	if mediaState := info.Properties["ID_CDROM_MEDIA_STATE"]; mediaState != "" {
+		fmt.Printf("  Media state:   %s\n", mediaState)
+	}
+	if audioTracks := info.Properties["ID_CDROM_MEDIA_TRACK_COUNT_AUDIO"]; audioTracks != "" {
+		fmt.Printf("  Audio tracks:  %s\n", audioTracks)
+	}
+}
+
+func runDrivesCommand(logger maokai.Logger) uint8 {
+	drives, err := listDrives(logger)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to list drives: %s", err)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+		return 1
+	}
+
+	if len(drives) == 0 {
+		fmt.Println("No optical drives found")
+		return 0
+	}
+
+	for _, info := range drives {
+		printDrive(info)
+	}
+
+	return 0
+}
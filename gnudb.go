@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mikogd/maokai"
+)
+
+// Set with GNUDB_URL
+var GNUDB_URL = "https://gnudb.gnudb.org/~cddb/cddb.cgi"
+
+// GnuDB asks clients to say who they are in every request, set with GNUDB_USER
+var GNUDB_USER = "sona"
+
+// Looks discs up on GnuDB, a freedb fork, with the CDDB protocol over HTTP
+type GnuDBProvider struct {
+	URL    string
+	User   string
+	Logger maokai.Logger
+}
+
+func NewGnuDBProvider(logger maokai.Logger) GnuDBProvider {
+	return GnuDBProvider{URL: GNUDB_URL, User: GNUDB_USER, Logger: logger}
+}
+
+func loadGnuDBConfig() {
+	if gnuDBURL := os.Getenv("GNUDB_URL"); gnuDBURL != "" {
+		GNUDB_URL = gnuDBURL
+	}
+	if gnuDBUser := os.Getenv("GNUDB_USER"); gnuDBUser != "" {
+		GNUDB_USER = gnuDBUser
+	}
+}
+
+func (provider GnuDBProvider) Name() string {
+	return SOURCE_GNUDB
+}
+
+// A disc GnuDB matched the query to
+type cddbMatch struct {
+	Category string
+	DiscID   string
+	Title    string
+}
+
+// Sends a CDDB command returning the status code and the lines following the status line
+func (provider GnuDBProvider) command(ctx context.Context, command string) (int, string, []string, error) {
+	query := url.Values{}
+	query.Set("cmd", command)
+	query.Set("hello", fmt.Sprintf("%s localhost sona 1.0", provider.User))
+	// Protocol 6 answers in UTF-8
+	query.Set("proto", "6")
+
+	body, err := providerGet(ctx, provider.URL+"?"+query.Encode(), nil, provider.Logger)
+	if err != nil {
+		return 0, "", nil, err
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	if !scanner.Scan() {
+		return 0, "", nil, errors.New("GnuDB sent an empty response")
+	}
+
+	statusLine := strings.TrimSpace(scanner.Text())
+	code, err := strconv.Atoi(strings.SplitN(statusLine, " ", 2)[0])
+	if err != nil {
+		errorMessage := fmt.Sprintf("GnuDB sent an unexpected status line: %s", statusLine)
+		return 0, "", nil, errors.New(errorMessage)
+	}
+
+	// A list ends with a line holding a single dot
+	lines := []string{}
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "." {
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	return code, statusLine, lines, scanner.Err()
+}
+
+// Parses a "category discid title" line of a query response
+func parseCDDBMatch(line string) (cddbMatch, bool) {
+	fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+	if len(fields) < 2 {
+		return cddbMatch{}, false
+	}
+
+	match := cddbMatch{Category: fields[0], DiscID: fields[1]}
+	if len(fields) == 3 {
+		match.Title = fields[2]
+	}
+
+	return match, true
+}
+
+func (provider GnuDBProvider) query(ctx context.Context, toc DiscTOC) ([]cddbMatch, error) {
+	args := []string{"cddb", "query", fmt.Sprintf("%08x", computeAccurateRipDiscID(toc).CDDBID), strconv.Itoa(len(toc.Offsets))}
+	for _, offset := range toc.Offsets {
+		args = append(args, strconv.Itoa(offset))
+	}
+	args = append(args, strconv.Itoa(toc.LeadOut/SECTORS_PER_SECOND))
+
+	code, statusLine, lines, err := provider.command(ctx, strings.Join(args, " "))
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []cddbMatch{}
+	switch code {
+	case 200:
+		// A single exact match is given on the status line
+		if match, ok := parseCDDBMatch(strings.SplitN(statusLine, " ", 2)[1]); ok {
+			matches = append(matches, match)
+		}
+	case 210, 211:
+		for _, line := range lines {
+			if match, ok := parseCDDBMatch(line); ok {
+				matches = append(matches, match)
+			}
+		}
+	case 202:
+		return nil, ErrNoRelease
+	default:
+		errorMessage := fmt.Sprintf("GnuDB query failed: %s", statusLine)
+		return nil, errors.New(errorMessage)
+	}
+
+	return matches, nil
+}
+
+var xmcdOffsetPattern = regexp.MustCompile(`^#\s+(\d+)\s*$`)
+
+// An xmcd entry as GnuDB stores it
+type xmcdEntry struct {
+	Fields map[string]string
+	// Start of each track in sectors including the lead-in, from the entry's comments
+	Offsets []int
+	// Length of the disc in seconds, from the entry's comments
+	Length int
+}
+
+// Parses an xmcd entry. Fields can be split over several lines, which are joined.
+func parseXmcdEntry(lines []string) xmcdEntry {
+	entry := xmcdEntry{Fields: map[string]string{}}
+	inOffsets := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			comment := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			switch {
+			case strings.HasPrefix(comment, "Track frame offsets"):
+				inOffsets = true
+			case strings.HasPrefix(comment, "Disc length:"):
+				inOffsets = false
+				fmt.Sscanf(strings.TrimPrefix(comment, "Disc length:"), "%d", &entry.Length)
+			case inOffsets && xmcdOffsetPattern.MatchString(line):
+				offset, _ := strconv.Atoi(xmcdOffsetPattern.FindStringSubmatch(line)[1])
+				entry.Offsets = append(entry.Offsets, offset)
+			}
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if found {
+			entry.Fields[key] += value
+		}
+	}
+
+	return entry
+}
+
+// Splits an xmcd "Artist / Title" into its parts, the artist is empty when there is no separator
+func splitXmcdTitle(title string) (string, string) {
+	if artist, title, found := strings.Cut(title, " / "); found {
+		return strings.TrimSpace(artist), strings.TrimSpace(title)
+	}
+
+	return "", strings.TrimSpace(title)
+}
+
+// Builds a release from an xmcd entry. It has a single medium with no position, GnuDB knows nothing of other discs.
+func releaseFromXmcd(entry xmcdEntry) ReleaseInfo {
+	credit := func(name string) []CreditedArtist {
+		return []CreditedArtist{{Name: name}}
+	}
+
+	albumArtist, albumTitle := splitXmcdTitle(entry.Fields["DTITLE"])
+	if albumArtist == "" {
+		albumArtist = albumTitle
+	}
+
+	release := ReleaseInfo{
+		Title:   albumTitle,
+		Date:    entry.Fields["DYEAR"],
+		Artists: credit(albumArtist),
+	}
+	if genre := entry.Fields["DGENRE"]; genre != "" {
+		release.Genres = []string{genre}
+	}
+
+	medium := MediumInfo{Format: "CD"}
+	for i, offset := range entry.Offsets {
+		trackArtist, trackTitle := splitXmcdTitle(entry.Fields[fmt.Sprintf("TTITLE%d", i)])
+		if trackArtist == "" {
+			trackArtist = albumArtist
+		}
+		if trackTitle == "" {
+			trackTitle = fmt.Sprintf("Track %02d", i+1)
+		}
+
+		// The last track runs to the end of the disc
+		end := entry.Length * SECTORS_PER_SECOND
+		if i+1 < len(entry.Offsets) {
+			end = entry.Offsets[i+1]
+		}
+
+		medium.Tracks = append(medium.Tracks, TrackInfo{
+			Position: uint8(i + 1),
+			Number:   strconv.Itoa(i + 1),
+			Title:    trackTitle,
+			Length:   uint32(max(end-offset, 0) * 1000 / SECTORS_PER_SECOND),
+			Artists:  credit(trackArtist),
+		})
+	}
+	release.Media = []MediumInfo{medium}
+
+	return release
+}
+
+func (provider GnuDBProvider) Lookup(ctx context.Context, disc DiscInfo) ([]ReleaseCandidate, error) {
+	matches, err := provider.query(ctx, disc.TOC)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := []ReleaseInfo{}
+	sourceIDs := []string{}
+	for _, match := range matches {
+		code, statusLine, lines, err := provider.command(ctx, fmt.Sprintf("cddb read %s %s", match.Category, match.DiscID))
+		if err != nil {
+			return nil, err
+		}
+		if code != 210 {
+			provider.Logger.CreateErrorLogf("Failed to read GnuDB entry %s/%s: %s", match.Category, match.DiscID, statusLine)
+			continue
+		}
+
+		releases = append(releases, releaseFromXmcd(parseXmcdEntry(lines)))
+		sourceIDs = append(sourceIDs, match.Category+"/"+match.DiscID)
+	}
+
+	if len(releases) == 0 {
+		return nil, ErrNoRelease
+	}
+
+	candidates := scoreReleases(SOURCE_GNUDB, releases, disc, RELEASE_PREFERENCES)
+	for i := range candidates {
+		candidates[i].SourceID = sourceIDs[i]
+	}
+	sortReleaseCandidates(candidates)
+
+	return candidates, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/mikogd/maokai"
+)
+
+// Pregaps up to 2 seconds are the standard gap before track 1 and never hold audio
+const HTOA_MIN_PREGAP_SECTORS = 2 * SECTORS_PER_SECOND
+
+// Peak level below which a ripped pregap is treated as silence, about -60 dBFS
+const HTOA_SILENCE_PEAK = 0.001
+
+const HTOA_TITLE = "Hidden Track"
+
+// Name cdparanoia's output for the hidden track gets, numbered 0 so it sorts and converts like the other tracks
+const HTOA_FILE_NAME = "track00.cdda.wav"
+
+// What was found in the pregap of track 1
+type HiddenTrackReport struct {
+	Sectors int
+	// False when the pregap turned out to be silent so nothing was kept
+	Ripped  bool
+	Peak    float64
+	CopyCRC uint32
+}
+
+// Length of the pregap before track 1 in sectors, the part of the disc before track 1's index 01
+func firstTrackPregap(toc DiscTOC) int {
+	if toc.FirstTrack != 1 {
+		return 0
+	}
+
+	return toc.Offsets[0] - LEAD_IN_SECTORS
+}
+
+// Rips the pregap of track 1 into HTOA_FILE_NAME when it is long enough to hold hidden audio. Returns nil when the
+// disc has no such pregap and a report with Ripped false when the pregap is silent.
+func ripHiddenTrack(settings RipSettings, toc DiscTOC, logger maokai.Logger) (*HiddenTrackReport, error) {
+	pregap := firstTrackPregap(toc)
+	if pregap <= HTOA_MIN_PREGAP_SECTORS {
+		return nil, nil
+	}
+
+	message := fmt.Sprintf("Track 1 has a %s pregap, checking it for hidden audio", formatSectors(pregap))
+	log.Println(message)
+	logger.CreateLog(message)
+
+	// cdparanoia treats the pregap of track 1 as track 0, the span is inclusive
+	span := fmt.Sprintf("0[.0]-0[.%d]", pregap-1)
+	args := []string{"-d", settings.Device, "-O", strconv.Itoa(settings.ReadOffset), "-w", span, HTOA_FILE_NAME}
+	progress := &paranoiaProgress{reporter: settings.Progress, toc: toc}
+	if _, err := runParanoia(args, progress, logger); err != nil {
+		return nil, err
+	}
+
+	samples, err := readWAVSamples(HTOA_FILE_NAME)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &HiddenTrackReport{Sectors: pregap}
+	report.CopyCRC, report.Peak = computePCMStats(samples)
+
+	if report.Peak < HTOA_SILENCE_PEAK {
+		message := "Pregap of track 1 is silent, there is no hidden track"
+		log.Println(message)
+		logger.CreateLog(message)
+		return report, os.Remove(HTOA_FILE_NAME)
+	}
+
+	report.Ripped = true
+	message = fmt.Sprintf("Found hidden track before track 1 with peak level %.1f %%", report.Peak*100)
+	log.Println(message)
+	logger.CreateLog(message)
+
+	return report, nil
+}
+
+// Tags for the hidden track, sharing the album fields of the disc's other songs
+func hiddenTrackFlacTags(report HiddenTrackReport, songs []FlacTags) FlacTags {
+	tags := FlacTags{
+		Title:       HTOA_TITLE,
+		TrackNumber: 0,
+		Length:      uint32(report.Sectors * 1000 / SECTORS_PER_SECOND),
+	}
+
+	if len(songs) > 0 {
+		tags.Artist = songs[0].AlbumArtist
+		tags.Album = songs[0].Album
+		tags.AlbumArtist = songs[0].AlbumArtist
+		tags.TrackTotal = songs[0].TrackTotal
+		tags.DiscNumber = songs[0].DiscNumber
+		tags.DiscTotal = songs[0].DiscTotal
+		tags.ReleaseDate = songs[0].ReleaseDate
+		tags.Genre = songs[0].Genre
+		tags.ArtistType = songs[0].ArtistType
+		tags.Barcode = songs[0].Barcode
+		tags.CatalogNumber = songs[0].CatalogNumber
+		tags.ReleaseID = songs[0].ReleaseID
+		tags.AlbumArtistIDs = songs[0].AlbumArtistIDs
+		tags.ArtistIDs = songs[0].AlbumArtistIDs
+		tags.ReleaseGroupID = songs[0].ReleaseGroupID
+		tags.DiscID = songs[0].DiscID
+		tags.ReleaseStatus = songs[0].ReleaseStatus
+		tags.ReleaseType = songs[0].ReleaseType
+		tags.ReleaseCountry = songs[0].ReleaseCountry
+	}
+
+	return tags
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHiddenTrackFileNameSkipsEarlierDiscs(t *testing.T) {
+	tests := []struct {
+		discNumber uint8
+		want       string
+	}{
+		{1, "00. Hidden Track.flac"},
+		{2, "2-00. Hidden Track.flac"},
+	}
+	for _, test := range tests {
+		song := FlacTags{Title: HTOA_TITLE, TrackNumber: 0, DiscNumber: test.discNumber}
+		if got := taggedFlacFileName(song, 11, discardLogger{}); got != test.want {
+			t.Errorf("Disc %d: taggedFlacFileName = %q, want %q", test.discNumber, got, test.want)
+		}
+	}
+}
+
+func TestImageCueSheetReferencesHiddenTrack(t *testing.T) {
+	sheet := CueSheet{
+		Title:               "Album",
+		TOC:                 DiscTOC{FirstTrack: 1, LastTrack: 2, Offsets: []int{3000, 20000}, LeadOut: 40000},
+		Tracks:              []CueTrack{{Number: 1, Pregap: 2850}, {Number: 2}},
+		HiddenTrackFileName: "00. Hidden Track.flac",
+		ImageFileName:       "Album.flac",
+	}
+
+	want := strings.Join([]string{
+		`FILE "00. Hidden Track.flac" WAVE`,
+		"  TRACK 01 AUDIO",
+		"    INDEX 00 00:00:00",
+		`FILE "Album.flac" WAVE`,
+		"    INDEX 01 00:00:00",
+		"  TRACK 02 AUDIO",
+		"    INDEX 01 03:46:50",
+		"",
+	}, "\r\n")
+	if got := sheet.Render(CueLayoutImage); !strings.HasSuffix(got, want) {
+		t.Errorf("Render returned\n%s\nwant it to end with\n%s", got, want)
+	}
+}
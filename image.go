@@ -0,0 +1,530 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-flac/flacvorbis/v2"
+	"github.com/go-flac/go-flac/v2"
+	"github.com/mikogd/maokai"
+)
+
+// Name cdparanoia's output for the whole disc gets, it converts to IMAGE_FLAC_FILE_NAME like the tracks do
+const IMAGE_WAV_FILE_NAME = "image.cdda.wav"
+
+const IMAGE_FLAC_FILE_NAME = "image.flac"
+
+// Track number the FLAC CUESHEET block gives the lead-out of a CD
+const CUESHEET_LEAD_OUT_TRACK = 170
+
+// Prefix of the Vorbis fields holding a track's tags in an image e.g. CUE_TRACK01_TITLE
+const CUE_TRACK_FIELD_PREFIX = "CUE_TRACK"
+
+// Vorbis field holding the number of tracks on the release's earlier discs, split tracks are numbered after them
+const IMAGE_TRACK_OFFSET_FIELD = "SONA_TRACKOFFSET"
+
+// Fields of the first track also written without a prefix so players show the image as the album
+var IMAGE_ALBUM_FIELDS = map[string]bool{
+	"ALBUM":         true,
+	"ALBUMARTIST":   true,
+	"TRACKTOTAL":    true,
+	"DISCNUMBER":    true,
+	"DISCTOTAL":     true,
+	"RELEASEDATE":   true,
+	"GENRE":         true,
+	"ARTISTTYPE":    true,
+	"BARCODE":       true,
+	"CATALOGNUMBER": true,
+
+	"MUSICBRAINZ_ALBUMID":        true,
+	"MUSICBRAINZ_ALBUMARTISTID":  true,
+	"MUSICBRAINZ_RELEASEGROUPID": true,
+	"MUSICBRAINZ_DISCID":         true,
+	"RELEASESTATUS":              true,
+	"RELEASETYPE":                true,
+	"RELEASECOUNTRY":             true,
+}
+
+var cueTrackFieldPattern = regexp.MustCompile(`^` + CUE_TRACK_FIELD_PREFIX + `(\d+)_(.+)$`)
+
+// Rips every track into IMAGE_WAV_FILE_NAME, reading the whole disc twice in secure mode. The secure read is nil when
+// not in secure mode.
+func ripImage(settings RipSettings, toc DiscTOC, logger maokai.Logger) (map[int][]ParanoiaEvent, *secureRead, error) {
+	// The span is inclusive so this runs from the start of the first track to the end of the last
+	span := fmt.Sprintf("%d-%d", toc.FirstTrack, toc.LastTrack)
+	progress := &paranoiaProgress{reporter: settings.Progress, toc: toc, wholeDisc: true}
+
+	if settings.SecureMode {
+		read, err := secureReadSpan(settings, "disc image", span, IMAGE_WAV_FILE_NAME, progress, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		message := fmt.Sprintf("Disc image: test CRC %08X, copy CRC %08X, copy OK", read.TestCRC, read.CopyCRC)
+		if read.Retries > 0 {
+			message = fmt.Sprintf("%s after %d retries", message, read.Retries)
+		}
+		log.Println(message)
+		logger.CreateLog(message)
+
+		return read.SuspiciousEvents, &read, nil
+	}
+
+	args := []string{"-d", settings.Device, "-O", strconv.Itoa(settings.ReadOffset), "-w", span, IMAGE_WAV_FILE_NAME}
+	suspiciousEvents, err := runParanoia(args, progress, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return suspiciousEvents, nil, nil
+}
+
+// Reads a track out of IMAGE_WAV_FILE_NAME, which starts at INDEX 01 of the first track
+func imageTrackSamples(toc DiscTOC) func(trackNumber int) ([]uint32, error) {
+	return func(trackNumber int) ([]uint32, error) {
+		start := (toc.TrackOffset(trackNumber) - toc.Offsets[0]) * SAMPLES_PER_SECTOR
+		count := toc.TrackSectors(trackNumber) * SAMPLES_PER_SECTOR
+
+		return readWAVSampleRange(IMAGE_WAV_FILE_NAME, start, count)
+	}
+}
+
+type FlacCueSheetIndex struct {
+	Number int
+	// Samples after the start of the track
+	Offset uint64
+}
+
+type FlacCueSheetTrack struct {
+	Number int
+	// Samples after the start of the image
+	Offset  uint64
+	ISRC    string
+	Indexes []FlacCueSheetIndex
+}
+
+// Contents of a FLAC CUESHEET metadata block for a CD, the last track is the lead-out
+type FlacCueSheet struct {
+	Catalog string
+	// Samples on the disc before INDEX 01 of the first track, which the image doesn't hold
+	LeadIn uint64
+	Tracks []FlacCueSheetTrack
+}
+
+// Converts a cue sheet in the image layout into a CUESHEET block's tracks and index points
+func newFlacCueSheet(sheet CueSheet) FlacCueSheet {
+	imageStart := sheet.TOC.Offsets[0]
+	flacSheet := FlacCueSheet{
+		Catalog: sheet.Catalog,
+		LeadIn:  uint64(imageStart * SAMPLES_PER_SECTOR),
+	}
+
+	for i, track := range sheet.Tracks {
+		indexOne := sheet.TOC.TrackOffset(track.Number) - imageStart
+		// The pregap of the first track is before the image starts
+		trackStart := indexOne
+		if i > 0 {
+			trackStart -= track.Pregap
+		}
+
+		flacTrack := FlacCueSheetTrack{
+			Number: track.Number,
+			Offset: uint64(trackStart * SAMPLES_PER_SECTOR),
+			ISRC:   track.ISRC,
+		}
+		if trackStart < indexOne {
+			flacTrack.Indexes = append(flacTrack.Indexes, FlacCueSheetIndex{Number: 0})
+		}
+		flacTrack.Indexes = append(flacTrack.Indexes, FlacCueSheetIndex{
+			Number: 1,
+			Offset: uint64((indexOne - trackStart) * SAMPLES_PER_SECTOR),
+		})
+		for j, position := range track.Indexes {
+			flacTrack.Indexes = append(flacTrack.Indexes, FlacCueSheetIndex{
+				Number: j + 2,
+				Offset: uint64((indexOne - trackStart + position) * SAMPLES_PER_SECTOR),
+			})
+		}
+
+		flacSheet.Tracks = append(flacSheet.Tracks, flacTrack)
+	}
+
+	flacSheet.Tracks = append(flacSheet.Tracks, FlacCueSheetTrack{
+		Number: CUESHEET_LEAD_OUT_TRACK,
+		Offset: uint64((sheet.TOC.LeadOut - imageStart) * SAMPLES_PER_SECTOR),
+	})
+
+	return flacSheet
+}
+
+// Writes value into a field of size bytes padded with NULs
+func writePaddedString(buffer *bytes.Buffer, value string, size int) {
+	field := make([]byte, size)
+	copy(field, value)
+	buffer.Write(field)
+}
+
+// Encodes the sheet in the layout of the FLAC format's METADATA_BLOCK_CUESHEET
+func (sheet FlacCueSheet) Marshal() flac.MetaDataBlock {
+	buffer := &bytes.Buffer{}
+	writePaddedString(buffer, sheet.Catalog, 128)
+	binary.Write(buffer, binary.BigEndian, sheet.LeadIn)
+	// The is CD flag followed by reserved bits
+	buffer.WriteByte(0x80)
+	buffer.Write(make([]byte, 258))
+	buffer.WriteByte(byte(len(sheet.Tracks)))
+
+	for _, track := range sheet.Tracks {
+		binary.Write(buffer, binary.BigEndian, track.Offset)
+		buffer.WriteByte(byte(track.Number))
+		writePaddedString(buffer, track.ISRC, 12)
+		// Audio track without pre-emphasis followed by reserved bits
+		buffer.WriteByte(0)
+		buffer.Write(make([]byte, 13))
+		buffer.WriteByte(byte(len(track.Indexes)))
+
+		for _, index := range track.Indexes {
+			binary.Write(buffer, binary.BigEndian, index.Offset)
+			buffer.WriteByte(byte(index.Number))
+			buffer.Write(make([]byte, 3))
+		}
+	}
+
+	return flac.MetaDataBlock{Type: flac.CueSheet, Data: buffer.Bytes()}
+}
+
+// Decodes a METADATA_BLOCK_CUESHEET
+func parseFlacCueSheet(data []byte) (FlacCueSheet, error) {
+	reader := bytes.NewReader(data)
+	sheet := FlacCueSheet{}
+
+	header := make([]byte, 128+8+259+1)
+	if _, err := reader.Read(header); err != nil || len(data) < len(header) {
+		return FlacCueSheet{}, errors.New("CUESHEET block is too short")
+	}
+	sheet.Catalog = strings.TrimRight(string(header[:128]), "\x00")
+	sheet.LeadIn = binary.BigEndian.Uint64(header[128:136])
+	trackCount := int(header[len(header)-1])
+
+	for i := 0; i < trackCount; i++ {
+		trackHeader := make([]byte, 8+1+12+14+1)
+		if n, _ := reader.Read(trackHeader); n != len(trackHeader) {
+			errorMessage := fmt.Sprintf("CUESHEET block ends in track %d of %d", i+1, trackCount)
+			return FlacCueSheet{}, errors.New(errorMessage)
+		}
+
+		track := FlacCueSheetTrack{
+			Offset: binary.BigEndian.Uint64(trackHeader[0:8]),
+			Number: int(trackHeader[8]),
+			ISRC:   strings.TrimRight(string(trackHeader[9:21]), "\x00"),
+		}
+
+		indexCount := int(trackHeader[len(trackHeader)-1])
+		for j := 0; j < indexCount; j++ {
+			indexData := make([]byte, 12)
+			if n, _ := reader.Read(indexData); n != len(indexData) {
+				errorMessage := fmt.Sprintf("CUESHEET block ends in an index of track %d", track.Number)
+				return FlacCueSheet{}, errors.New(errorMessage)
+			}
+
+			track.Indexes = append(track.Indexes, FlacCueSheetIndex{
+				Offset: binary.BigEndian.Uint64(indexData[0:8]),
+				Number: int(indexData[8]),
+			})
+		}
+
+		sheet.Tracks = append(sheet.Tracks, track)
+	}
+
+	return sheet, nil
+}
+
+// Sample of the image the track's INDEX 01 is at
+func (track FlacCueSheetTrack) IndexOneSample() uint64 {
+	for _, index := range track.Indexes {
+		if index.Number == 1 {
+			return track.Offset + index.Offset
+		}
+	}
+
+	return track.Offset
+}
+
+// Vorbis comments of an image, the album's fields plus every track's fields prefixed with CUE_TRACKnn_
+func imageVorbisComments(songs []FlacTags, trackOffset uint8) *flacvorbis.MetaDataBlockVorbisComment {
+	comments := flacvorbis.New()
+	if len(songs) == 0 {
+		return comments
+	}
+
+	comments.Add(IMAGE_TRACK_OFFSET_FIELD, strconv.Itoa(int(trackOffset)))
+
+	comments.Add("TITLE", songs[0].Album)
+	for _, albumArtist := range songs[0].AlbumArtist {
+		comments.Add("ARTIST", albumArtist)
+	}
+	for _, field := range flacTagFields(songs[0]) {
+		if IMAGE_ALBUM_FIELDS[field.Name] {
+			comments.Add(field.Name, field.Value)
+		}
+	}
+
+	for _, song := range songs {
+		for _, field := range flacTagFields(song) {
+			comments.Add(fmt.Sprintf("%s%02d_%s", CUE_TRACK_FIELD_PREFIX, song.TrackNumber, field.Name), field.Value)
+		}
+	}
+
+	return comments
+}
+
+// The image's IMAGE_TRACK_OFFSET_FIELD, 0 for images written before it was added
+func imageTrackOffset(comments *flacvorbis.MetaDataBlockVorbisComment) uint8 {
+	values, err := comments.Get(IMAGE_TRACK_OFFSET_FIELD)
+	if err != nil || len(values) == 0 {
+		return 0
+	}
+
+	trackOffset, _ := strconv.Atoi(values[0])
+	return uint8(trackOffset)
+}
+
+// Rebuilds the tags of every track stored in an image's CUE_TRACKnn_ fields, ordered by track number
+func imageFlacTags(comments *flacvorbis.MetaDataBlockVorbisComment) []FlacTags {
+	fieldsByTrack := map[int][]flacTagField{}
+	for _, comment := range comments.Comments {
+		name, value, found := strings.Cut(comment, "=")
+		if !found {
+			continue
+		}
+
+		match := cueTrackFieldPattern.FindStringSubmatch(strings.ToUpper(name))
+		if match == nil {
+			continue
+		}
+
+		trackNumber, _ := strconv.Atoi(match[1])
+		fieldsByTrack[trackNumber] = append(fieldsByTrack[trackNumber], flacTagField{match[2], value})
+	}
+
+	songs := []FlacTags{}
+	for trackNumber, fields := range fieldsByTrack {
+		song := flacTagsFromFields(fields)
+		song.TrackNumber = uint8(trackNumber)
+		songs = append(songs, song)
+	}
+	sort.Slice(songs, func(i, j int) bool {
+		return songs[i].TrackNumber < songs[j].TrackNumber
+	})
+
+	return songs
+}
+
+// Saves the converted image as destFileName with the sheet's CUESHEET block and the songs' tags
+func writeImageFlac(
+	sourceFileName string,
+	destFileName string,
+	sheet CueSheet,
+	songs []FlacTags,
+	trackOffset uint8,
+	cover *CoverArt,
+	logger maokai.Logger,
+) error {
+	flacFile, err := flac.ParseFile(sourceFileName)
+	if err != nil {
+		return err
+	}
+
+	// ffmpeg's own comments are replaced and any cue sheet it wrote doesn't know the disc's pregaps
+	meta := []*flac.MetaDataBlock{}
+	for _, block := range flacFile.Meta {
+		if block.Type != flac.VorbisComment && block.Type != flac.CueSheet {
+			meta = append(meta, block)
+		}
+	}
+
+	cueSheetMeta := newFlacCueSheet(sheet).Marshal()
+	commentsMeta := imageVorbisComments(songs, trackOffset).Marshal()
+	flacFile.Meta = append(meta, &cueSheetMeta, &commentsMeta)
+	setFlacCover(flacFile, cover)
+
+	logger.CreateLog(fmt.Sprintf("Saving disc image %s", destFileName))
+	return flacFile.Save(destFileName)
+}
+
+// Reads the CUESHEET block, tags, track offset and front cover of an image without reading its audio. The cover is nil
+// when the image has none.
+func readImageFlac(fileName string) (FlacCueSheet, []FlacTags, uint8, *CoverArt, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return FlacCueSheet{}, nil, 0, nil, err
+	}
+	defer file.Close()
+
+	flacFile, err := flac.ParseMetadata(file)
+	if err != nil {
+		return FlacCueSheet{}, nil, 0, nil, err
+	}
+
+	var sheet *FlacCueSheet
+	for _, block := range flacFile.Meta {
+		if block.Type == flac.CueSheet {
+			parsedSheet, err := parseFlacCueSheet(block.Data)
+			if err != nil {
+				return FlacCueSheet{}, nil, 0, nil, err
+			}
+			sheet = &parsedSheet
+		}
+	}
+	if sheet == nil || len(sheet.Tracks) < 2 {
+		errorMessage := fmt.Sprintf("%s has no CUESHEET block with tracks", fileName)
+		return FlacCueSheet{}, nil, 0, nil, errors.New(errorMessage)
+	}
+
+	comments, _, err := ExtractFLACComment(flacFile)
+	if err != nil {
+		return FlacCueSheet{}, nil, 0, nil, err
+	}
+	if comments == nil {
+		errorMessage := fmt.Sprintf("%s has no tags", fileName)
+		return FlacCueSheet{}, nil, 0, nil, errors.New(errorMessage)
+	}
+
+	cover, err := findFlacCover(flacFile)
+	if err != nil {
+		return FlacCueSheet{}, nil, 0, nil, err
+	}
+
+	return *sheet, imageFlacTags(comments), imageTrackOffset(comments), cover, nil
+}
+
+// Cuts the samples from start up to end out of the image into fileName
+func extractImageTrack(imageFileName string, start uint64, end uint64, fileName string, logger maokai.Logger) error {
+	trim := fmt.Sprintf("atrim=start_sample=%d:end_sample=%d,asetpts=N/SR/TB", start, end)
+	// The image's Vorbis comments hold the whole album's tags so they aren't copied into the track
+	args := []string{"-nostats", "-loglevel", "error", "-y", "-i", imageFileName, "-map", "0:a", "-map_metadata", "-1",
+		"-af", trim, "-c:a", "flac", "-compression_level", "5", fileName}
+	logger.CreateLog(fmt.Sprintf("Running command ffmpeg %s", strings.Join(args, " ")))
+
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// Splits an image into a file per track, from each track's INDEX 01 to the next one's like the normal rip, and
+// tags them the same way
+func splitImage(imageFileName string, logger maokai.Logger) error {
+	sheet, songs, trackOffset, cover, err := readImageFlac(imageFileName)
+	if err != nil {
+		return err
+	}
+
+	songsByTrack := map[int]FlacTags{}
+	for _, song := range songs {
+		songsByTrack[int(song.TrackNumber)] = song
+	}
+
+	splitSongs := []FlacTags{}
+	// The last track of the sheet is the lead-out
+	for i, track := range sheet.Tracks[:len(sheet.Tracks)-1] {
+		song, found := songsByTrack[track.Number]
+		if !found {
+			errorMessage := fmt.Sprintf("%s has no tags for track %d", imageFileName, track.Number)
+			return errors.New(errorMessage)
+		}
+
+		start := track.IndexOneSample()
+		end := sheet.Tracks[i+1].IndexOneSample()
+		if sheet.Tracks[i+1].Number == CUESHEET_LEAD_OUT_TRACK {
+			end = sheet.Tracks[i+1].Offset
+		}
+
+		fileName := fmt.Sprintf("%02d. %s-no-tags.flac", song.TrackNumber, sanitizeSongName(logger, song.Title))
+		message := fmt.Sprintf("Splitting track %02d from %s", track.Number, imageFileName)
+		log.Println(message)
+		logger.CreateLog(message)
+		if err := extractImageTrack(imageFileName, start, end, fileName, logger); err != nil {
+			errorMessage := fmt.Sprintf("Failed to split track %02d from %s: %s", track.Number, imageFileName, err)
+			return errors.New(errorMessage)
+		}
+
+		splitSongs = append(splitSongs, song)
+	}
+
+	if err := AddFLACTags(splitSongs, trackOffset, cover, logger); err != nil {
+		return err
+	}
+
+	for _, song := range splitSongs {
+		fileName := fmt.Sprintf("%02d. %s-no-tags.flac", song.TrackNumber, sanitizeSongName(logger, song.Title))
+		if err := os.Remove(fileName); err != nil {
+			logger.CreateErrorLog(fmt.Sprintf("Failed to remove %s", fileName))
+		}
+	}
+
+	return nil
+}
+
+func runSplitCommand(args []string, logger *maokai.FileLogger) uint8 {
+	flags := flag.NewFlagSet("split", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: sona split <image flac>")
+		fmt.Fprintln(flags.Output(), "Splits a disc image ripped with --image into tagged track files next to it")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	imagePath, err := filepath.Abs(flags.Arg(0))
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	startingWorkingDirectory, err := os.Getwd()
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to get the current working directory: %s", err)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+		return 1
+	}
+
+	// The track files are written next to the image
+	if err := os.Chdir(filepath.Dir(imagePath)); err != nil {
+		errorMessage := fmt.Sprintf("Failed to change current working directory to %s: %s", filepath.Dir(imagePath), err)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+		return 1
+	}
+	defer changeDirectory(startingWorkingDirectory)
+
+	if err := splitImage(filepath.Base(imagePath), logger); err != nil {
+		errorMessage := fmt.Sprintf("Failed to split %s: %s", imagePath, err)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-flac/flacvorbis/v2"
+	"github.com/go-flac/go-flac/v2"
+)
+
+func TestImageVorbisCommentsKeepTrackOffset(t *testing.T) {
+	songs := []FlacTags{
+		{Title: "Intro", TrackNumber: 1, TrackTotal: 2, DiscNumber: 2, Album: "Album"},
+		{Title: "Outro", TrackNumber: 2, TrackTotal: 2, DiscNumber: 2, Album: "Album"},
+	}
+
+	marshalled := imageVorbisComments(songs, 14).Marshal()
+	comments, err := flacvorbis.ParseFromMetaDataBlock(marshalled)
+	if err != nil {
+		t.Fatalf("ParseFromMetaDataBlock: %v", err)
+	}
+
+	if trackOffset := imageTrackOffset(comments); trackOffset != 14 {
+		t.Errorf("imageTrackOffset = %d, want 14", trackOffset)
+	}
+
+	splitSongs := imageFlacTags(comments)
+	if len(splitSongs) != 2 {
+		t.Fatalf("imageFlacTags returned %d songs, want 2", len(splitSongs))
+	}
+
+	// Disc 2's first track is named like the 15th track of a normal rip
+	if fileName := taggedFlacFileName(splitSongs[0], 14, discardLogger{}); fileName != "15. Intro.flac" {
+		t.Errorf("taggedFlacFileName = %s, want 15. Intro.flac", fileName)
+	}
+}
+
+func TestImageTrackOffsetMissing(t *testing.T) {
+	if trackOffset := imageTrackOffset(flacvorbis.New()); trackOffset != 0 {
+		t.Errorf("imageTrackOffset of an image without the field = %d, want 0", trackOffset)
+	}
+}
+
+func TestSplitTrackTagsDropImageComments(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	songs := []FlacTags{
+		{Title: "Intro", Artist: []string{"Artist"}, TrackNumber: 1, TrackTotal: 2, DiscNumber: 2, Album: "Album"},
+		{Title: "Outro", Artist: []string{"Artist"}, TrackNumber: 2, TrackTotal: 2, DiscNumber: 2, Album: "Album"},
+	}
+	imageComments := imageVorbisComments(songs, 14)
+	imageComments.Add("TITLE", "Album")
+	imageComments.Add("ARTIST", "Artist")
+
+	// A split track still holding the image's comments, with a frame sync code standing in for its audio
+	imageCommentsMeta := imageComments.Marshal()
+	split := flac.File{
+		Meta:   []*flac.MetaDataBlock{{Type: flac.StreamInfo, Data: make([]byte, 34)}, &imageCommentsMeta},
+		Frames: bytes.NewReader([]byte{0xFF, 0xF8}),
+	}
+	if err := split.Save("01. Intro-no-tags.flac"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddFLACTags(songs[:1], 14, nil, discardLogger{}); err != nil {
+		t.Fatalf("AddFLACTags: %v", err)
+	}
+
+	tagged, err := flac.ParseFile("15. Intro.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tagged.Close()
+
+	comments, _, err := ExtractFLACComment(tagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field, want := range map[string][]string{"TITLE": {"Intro"}, "ARTIST": {"Artist"}} {
+		if values, _ := comments.Get(field); !slices.Equal(values, want) {
+			t.Errorf("%s = %v, want %v", field, values, want)
+		}
+	}
+	for _, comment := range comments.Comments {
+		if strings.HasPrefix(comment, CUE_TRACK_FIELD_PREFIX) || strings.HasPrefix(comment, IMAGE_TRACK_OFFSET_FIELD) {
+			t.Errorf("Split track kept the image's %s", comment)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mikogd/maokai"
+)
+
+// Index information of a track that the TOC doesn't hold
+type TrackIndexInfo struct {
+	ISRC string
+	// Sectors of the track's pregap, the audio between INDEX 00 and INDEX 01
+	Pregap int
+	// Positions of INDEX 02 onwards in sectors after INDEX 01
+	Indexes []int
+}
+
+// Pregaps, index points, ISRCs and catalog number read from the disc's subchannel
+type TrackIndexScan struct {
+	Catalog string
+	// Keyed by track number
+	Tracks map[int]TrackIndexInfo
+}
+
+var (
+	cdrdaoQuotedPattern = regexp.MustCompile(`^(CATALOG|ISRC) "([^"]*)"`)
+	cdrdaoTimePattern   = regexp.MustCompile(`^(START|INDEX) (\d+):(\d+):(\d+)`)
+)
+
+func parseCdrdaoTime(minutes string, seconds string, frames string) int {
+	m, _ := strconv.Atoi(minutes)
+	s, _ := strconv.Atoi(seconds)
+	f, _ := strconv.Atoi(frames)
+
+	return (m*60+s)*SECTORS_PER_SECOND + f
+}
+
+// Parses the toc file written by `cdrdao read-toc`. Tracks are numbered in the order they appear from firstTrack.
+func parseCdrdaoTOC(reader io.Reader, firstTrack int) (TrackIndexScan, error) {
+	scan := TrackIndexScan{Tracks: map[int]TrackIndexInfo{}}
+
+	trackNumber := firstTrack - 1
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "TRACK ") {
+			trackNumber++
+			scan.Tracks[trackNumber] = TrackIndexInfo{}
+			continue
+		}
+
+		if match := cdrdaoQuotedPattern.FindStringSubmatch(line); match != nil {
+			if match[1] == "CATALOG" {
+				scan.Catalog = match[2]
+			} else if track, found := scan.Tracks[trackNumber]; found {
+				track.ISRC = match[2]
+				scan.Tracks[trackNumber] = track
+			}
+			continue
+		}
+
+		if match := cdrdaoTimePattern.FindStringSubmatch(line); match != nil {
+			track, found := scan.Tracks[trackNumber]
+			if !found {
+				continue
+			}
+
+			position := parseCdrdaoTime(match[2], match[3], match[4])
+			if match[1] == "START" {
+				track.Pregap = position
+			} else {
+				track.Indexes = append(track.Indexes, position)
+			}
+			scan.Tracks[trackNumber] = track
+		}
+	}
+
+	return scan, scanner.Err()
+}
+
+// Scans the disc's subchannel with cdrdao for pregaps, index points and ISRCs. This reads the whole disc so it takes
+// a few minutes.
+func scanTrackIndexes(device string, toc DiscTOC, logger maokai.Logger) (TrackIndexScan, error) {
+	if _, err := exec.LookPath("cdrdao"); err != nil {
+		return TrackIndexScan{}, errors.New("cdrdao isn't installed, pregaps and indexes can't be detected")
+	}
+
+	tempDirectory, err := os.MkdirTemp("", "sona-toc-")
+	if err != nil {
+		return TrackIndexScan{}, err
+	}
+	defer os.RemoveAll(tempDirectory)
+
+	tocFileName := filepath.Join(tempDirectory, "disc.toc")
+	args := []string{"read-toc", "--device", device, "--datafile", "disc.bin", tocFileName}
+	logger.CreateLogf("Running command cdrdao %s", strings.Join(args, " "))
+	cmd := exec.Command("cdrdao", args...)
+	cmd.Stdout = loggerWriter{logger}
+	cmd.Stderr = loggerWriter{logger}
+
+	if err := cmd.Run(); err != nil {
+		errorMessage := fmt.Sprintf("Failed to run cdrdao read-toc: %s", err)
+		return TrackIndexScan{}, errors.New(errorMessage)
+	}
+
+	file, err := os.Open(tocFileName)
+	if err != nil {
+		return TrackIndexScan{}, err
+	}
+	defer file.Close()
+
+	return parseCdrdaoTOC(file, toc.FirstTrack)
+}
@@ -0,0 +1,11 @@
+package main
+
+// Discards every log so the code under test can be given a maokai.Logger
+type discardLogger struct{}
+
+func (discardLogger) CreateLog(body string) error                   { return nil }
+func (discardLogger) CreateLogf(format string, a ...any) error      { return nil }
+func (discardLogger) CreateDebugLog(body string) error              { return nil }
+func (discardLogger) CreateDebugLogf(format string, a ...any) error { return nil }
+func (discardLogger) CreateErrorLog(body string) error              { return nil }
+func (discardLogger) CreateErrorLogf(format string, a ...any) error { return nil }
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mikogd/hextech/env"
 	"github.com/mikogd/maokai"
@@ -18,9 +22,131 @@ func changeDirectory(path string) {
 	}
 }
 
-func start() uint8 {
-	env.LoadEnv("./.env")
+// Options for ripping a disc, parsed from the command line
+type RipOptions struct {
+	// Device path of the CD drive, empty to use the first CD drive found
+	Device string
+	// Disc number of the release being ripped, empty to work it out from the release
+	DiscNumber string
+	// Read each track twice and compare the reads
+	Secure bool
+	// Re-reads allowed per track in secure mode when the reads don't match
+	Retries int
+	// Skip scanning the disc for pregaps and index points with cdrdao
+	SkipIndexScan bool
+	// Rip the disc into a single flac with an embedded cue sheet instead of a file per track
+	Image bool
+	// Look the disc up on MusicBrainz even when an earlier lookup is cached
+	Refresh bool
+	// MBID of the release to tag the disc with, skipping the pick
+	ReleaseID string
+	// How the release is picked when the disc matches several
+	Pick ReleasePickPolicy
+	// Open the tags in $EDITOR to review them before ripping
+	Review bool
+	// Tag the disc from this file instead of looking it up
+	TagsFile string
+	// Open the MusicBrainz submission page in a browser when the disc isn't known
+	OpenSubmissionURL bool
+	// How long to wait for the disc to be submitted to MusicBrainz when it isn't known before asking the next provider,
+	// 0 not to wait
+	WaitForSubmit time.Duration
+	// Called once the release and disc number are known, before ripping. An error aborts the rip.
+	OnReleaseChosen func(release ReleaseInfo, disc DiscInfo, discNumber uint8) error
+}
+
+func parseRipOptions(args []string) (RipOptions, error) {
+	options := RipOptions{}
+
+	flags := flag.NewFlagSet("sona", flag.ContinueOnError)
+	flags.StringVar(&options.Device, "device", "", "CD drive to rip from e.g. /dev/sr1, defaults to the first CD drive found")
+	flags.BoolVar(&options.Secure, "secure", false, "test and copy every track, re-reading tracks whose reads don't match")
+	flags.IntVar(&options.Retries, "retries", 3, "re-reads allowed per track in secure mode before the rip fails")
+	flags.BoolVar(&options.SkipIndexScan, "no-index-scan", false, "don't scan for pregaps and index points, the cue sheet only gets the TOC")
+	flags.BoolVar(&options.Image, "image", false, "rip the disc into a single flac with an embedded cue sheet instead of a file per track")
+	flags.BoolVar(&options.Refresh, "refresh", false, "look the disc up on MusicBrainz even when an earlier lookup is cached")
+	flags.StringVar(&options.ReleaseID, "release", "", "MusicBrainz ID of the release to tag the disc with")
+	flags.BoolVar(&options.Review, "review", false, "open the tags in $EDITOR to review them before ripping")
+	flags.StringVar(&options.TagsFile, "tags-file", "", "tag the disc from a tags file like the one --review opens instead of looking it up")
+	flags.BoolVar(&options.OpenSubmissionURL, "open-submit", false, "open the MusicBrainz submission page in a browser when MusicBrainz doesn't know the disc")
+	flags.DurationVar(&options.WaitForSubmit, "wait-for-submit", 0, "when MusicBrainz doesn't know the disc, wait this long e.g. 30m for it to be submitted and then rip it")
+	pick := flags.String("pick", "", "how to pick between releases: ask, best or first, defaults to ask when run from a terminal and best otherwise")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage:")
+		fmt.Fprintln(flags.Output(), "  sona [options] [disc number]         rip, tag and store the inserted disc, the disc number overrides the detected one")
+		fmt.Fprintln(flags.Output(), "  sona drives                          list optical drives and their udev properties")
+		fmt.Fprintln(flags.Output(), "  sona watch [--device <dev>]          rip every audio disc inserted, ejecting it when done")
+		fmt.Fprintln(flags.Output(), "  sona detect-offset [--save]          work out the drive's read offset from an AccurateRip key disc")
+		fmt.Fprintln(flags.Output(), "  sona verify-log <rip log>            check a rip log hasn't been edited since it was written")
+		fmt.Fprintln(flags.Output(), "  sona split <image flac>              split a disc image ripped with --image into tagged track files")
+		fmt.Fprintln(flags.Output(), "  sona session [options]               rip every disc of a multi-disc release, resuming a saved session")
+		fmt.Fprintln(flags.Output(), "  sona session abandon                 forget the saved session")
+		fmt.Fprintln(flags.Output(), "  sona tag-pending [options]           tag and store the discs ripped while metadata couldn't be looked up")
+		fmt.Fprintln(flags.Output(), "\nTags files are JSON with album fields and a tracks list, --review writes one to start from.")
+		fmt.Fprintln(flags.Output(), "\nOptions:")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return options, err
+	}
+
+	if flags.NArg() > 1 {
+		err := fmt.Errorf("Unexpected arguments: %v", flags.Args()[1:])
+		fmt.Fprintln(flags.Output(), err)
+		flags.Usage()
+		return options, err
+	}
+
+	options.DiscNumber = flags.Arg(0)
+
+	options.Pick, err = parseReleasePickPolicy(*pick)
+	if err != nil {
+		fmt.Fprintln(flags.Output(), err)
+		return options, err
+	}
+
+	return options, nil
+}
+
+// Overrides the service URLs, release preferences, cover art and metadata provider settings with the ones set in the
+// environment
+func loadConfig() {
+	if accurateRipURL := os.Getenv("ACCURATERIP_URL"); accurateRipURL != "" {
+		ACCURATERIP_URL = accurateRipURL
+	}
+	if musicBrainzURL := os.Getenv("MUSICBRAINZ_URL"); musicBrainzURL != "" {
+		API_URL = musicBrainzURL
+	}
+	if musicBrainzWebURL := os.Getenv("MUSICBRAINZ_WEB_URL"); musicBrainzWebURL != "" {
+		MUSICBRAINZ_WEB_URL = musicBrainzWebURL
+	}
+	if err := loadReleasePreferences(); err != nil {
+		log.Fatalf("Failed to load release preferences: %s\n", err)
+	}
+	if err := loadCoverArtConfig(); err != nil {
+		log.Fatalf("Failed to load cover art settings: %s\n", err)
+	}
+	if err := loadMetadataProviders(); err != nil {
+		log.Fatalf("Failed to load metadata providers: %s\n", err)
+	}
+	loadGnuDBConfig()
+	loadDiscogsConfig()
+	PENDING_DIR = os.Getenv("PENDING_DIR")
+}
+
+// Base name of the album's rip log and cue sheet, releases with several discs get one of each per disc
+func albumFileBaseName(albumName string, discNumber uint8, release ReleaseInfo, logger maokai.Logger) string {
+	baseName := sanitizeSongName(logger, albumName)
+	if len(release.Media) > 1 {
+		baseName = fmt.Sprintf("%s (Disc %d)", baseName, discNumber)
+	}
+
+	return baseName
+}
 
+func createLogger() *maokai.FileLogger {
 	loggerConfig := maokai.LoggerConfig{
 		LogDirectoryPath: "/var/log/sona-cli",
 		LogName: "sona-cli.log",
@@ -29,53 +155,154 @@ func start() uint8 {
 	logger, err := maokai.CreateLogger(loggerConfig)
 
 	if err != nil {
-		errorMessage := fmt.Sprintf("Failed to create logger: %s\n", err)
+		log.Fatalf("Failed to create logger: %s\n", err)
+	}
+
+	return logger
+}
+
+func start(options RipOptions, logger *maokai.FileLogger) uint8 {
+	device, err := resolveCDDrive(options.Device, logger)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to get CD drive: %v", err)
 		logger.CreateErrorLog(errorMessage)
-		log.Fatalln(errorMessage)
+		log.Println(errorMessage)
+		return 1
 	}
 
-	metadata, err := GetMetaDataForCD(logger)
+	driveInfo, err := getDriveInfo(device)
 	if err != nil {
-		errorMessage := fmt.Sprintf("Failed to get meta data: %v", err)
+		errorMessage := fmt.Sprintf("Failed to get drive info for %s: %v", device, err)
 		logger.CreateErrorLog(errorMessage)
 		log.Println(errorMessage)
 		return 1
 	}
 
-	release := GetRelease(metadata, logger)
+	readOffset, err := getDriveOffset(driveInfo, logger)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to get read offset for %s: %v", device, err)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+		return 1
+	}
 
-	pathToMusicFolder := os.Getenv("PATH_TO_DEST_MUSIC")
-	if pathToMusicFolder == "" {
-		logger.CreateLog("Failed to get PATH_TO_DEST_MUSIC environment variable")
-		log.Fatalf("Failed to get PATH_TO_DEST_MUSIC environment variable")
+	disc, err := ReadDiscInfo(device, logger)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to read disc: %v", err)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+		return 1
 	}
-	logger.CreateLog(fmt.Sprintf("Path to folder %s", pathToMusicFolder))
 
-	artistName := release.AristCredit.NameCredit[0].Artist.Name
-	albumName := release.Title
-	pathToAlbum := path.Join(pathToMusicFolder, artistName, sanitizeSongName(logger, albumName))
-	if _, err := os.Stat(pathToAlbum); os.IsNotExist(err) {
-		log.Printf("%s doesn't exist, creating directory\n", pathToAlbum)
-		logger.CreateLog(fmt.Sprintf("%s doesn't exist, creating directory", pathToAlbum))
-		if err = os.MkdirAll(pathToAlbum, 0777); err != nil {
-			errorMessage := fmt.Sprintf("Failed to create directory %s: %s\n", pathToAlbum, err)
-			log.Println(errorMessage)
+	// The disc number is worked out from the release, the argument only overrides it
+	var discNumberOverride int
+	if options.DiscNumber != "" {
+		discNumberOverride, err = strconv.Atoi(options.DiscNumber)
+		if err != nil || discNumberOverride < 1 || discNumberOverride > 255 {
+			errorMessage := fmt.Sprintf("Failed to convert disc number '%s' to a disc number", options.DiscNumber)
 			logger.CreateErrorLog(errorMessage)
+			log.Println(errorMessage)
 			return 1
 		}
-	} else if err != nil {
-		// Some other error while trying to check the folder
-		errorMessage := fmt.Sprintf("Error checking directory %s: %s", pathToAlbum, err)
-		log.Println(errorMessage)
+	}
+
+	musicBrainz := NewMusicBrainzClient(API_URL, logger)
+	metadataCache, err := NewMetadataCache(options.Refresh, logger)
+	if err != nil {
+		// Lookups still work without the cache, they just aren't kept
+		errorMessage := fmt.Sprintf("Failed to open the metadata cache: %v", err)
 		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+	}
+
+	// CD-Text and GnuDB don't know the disc number, it is the override or the first disc
+	unknownDiscNumber := uint8(max(discNumberOverride, 1))
+
+	var release ReleaseInfo
+	var discNumber uint8
+	var songs []FlacTags
+	if options.TagsFile != "" {
+		tagsFile, err := loadTagsFile(options.TagsFile, disc, uint8(discNumberOverride))
+		if err != nil {
+			logger.CreateErrorLog(err.Error())
+			log.Println(err)
+			return 1
+		}
+
+		release = releaseFromTagsFile(tagsFile)
+		discNumber = tagsFile.DiscNumber
+		songs = tagsFile.Songs()
+	} else {
+		providers := newMetadataProviders(musicBrainz, metadataCache, options, logger)
+		release, err = chooseRelease(options, providers, musicBrainz, disc, unknownDiscNumber, logger)
+		if errors.Is(err, ErrLookupFailed) {
+			return ripPending(options, device, driveInfo, readOffset, disc, uint8(discNumberOverride), logger)
+		}
+		if err != nil {
+			errorMessage := fmt.Sprintf("Failed to pick a release: %v", err)
+			logger.CreateErrorLog(errorMessage)
+			log.Println(errorMessage)
+			return 1
+		}
+
+		discNumber = resolveDiscNumber(release, disc, uint8(discNumberOverride), logger)
+		songs, err = GetFlacTags(release, discNumber, logger)
+		if err != nil {
+			logger.CreateErrorLog(err.Error())
+			log.Println(err)
+			return 1
+		}
+		applyDiscCodes(songs, disc, release, discNumber, logger)
+	}
+
+	message := fmt.Sprintf("discNumber: %d", discNumber)
+	log.Println(message)
+	logger.CreateLog(message)
+
+	if options.Review {
+		if !isTerminal(os.Stdin) {
+			logger.CreateErrorLog("Not reviewing the tags, there is no terminal to run the editor in")
+		} else {
+			songs, err = reviewFlacTags(songs, disc, logger)
+			if err != nil {
+				errorMessage := fmt.Sprintf("Failed to review tags: %v", err)
+				logger.CreateErrorLog(errorMessage)
+				log.Println(errorMessage)
+				return 1
+			}
+			applyAlbumTags(&release, songs)
+
+			if reviewed := songs[0].DiscNumber; reviewed != discNumber {
+				message := fmt.Sprintf("Disc number changed from %d to %d in review", discNumber, reviewed)
+				log.Println(message)
+				logger.CreateLog(message)
+				discNumber = reviewed
+			}
+		}
+	}
+
+	if options.OnReleaseChosen != nil {
+		if err := options.OnReleaseChosen(release, disc, discNumber); err != nil {
+			errorMessage := fmt.Sprintf("Not ripping disc %s: %v", disc.ID, err)
+			logger.CreateErrorLog(errorMessage)
+			log.Println(errorMessage)
+			return 1
+		}
+	}
+
+	pathToAlbum, err := createAlbumDirectory(release, logger)
+	if err != nil {
+		log.Println(err)
+		logger.CreateErrorLog(err.Error())
 		return 1
 	}
 
 	startingWorkingDirectory, err := os.Getwd()
 	if err != nil {
 		errorMessage := fmt.Sprintf("Failed to get the current working directory: %s", err)
-		logger.CreateLog(errorMessage) 
-		log.Fatalln(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+		return 1
 	}
 
 	if err = os.Chdir(pathToAlbum); err != nil {
@@ -88,44 +315,114 @@ func start() uint8 {
 
 	defer changeDirectory(startingWorkingDirectory)
 
-	if err := RipCD(pathToAlbum, logger); err != nil {
+	ripReport, indexScan, err := ripDisc(options, device, readOffset, disc, pathToAlbum, logger)
+	if err != nil {
 		errorMessage := fmt.Sprintf("Failed to rip CD: %s", err)
 		log.Println(errorMessage)
 		logger.CreateErrorLog(errorMessage)
-		changeDirectory(startingWorkingDirectory)
-		os.Exit(1)
+		return 1
 	}
 
-	discNumberArg := ""
-	if len(os.Args) == 2 {
-		discNumberArg = os.Args[1]
+	return tagRip(options.Image, release, disc, discNumber, songs, driveInfo, ripReport, indexScan, pathToAlbum, logger)
+}
+
+// Creates the album's folder in PATH_TO_DEST_MUSIC if it doesn't exist yet, returning its path
+func createAlbumDirectory(release ReleaseInfo, logger maokai.Logger) (string, error) {
+	pathToMusicFolder := os.Getenv("PATH_TO_DEST_MUSIC")
+	if pathToMusicFolder == "" {
+		return "", errors.New("Failed to get PATH_TO_DEST_MUSIC environment variable")
 	}
+	logger.CreateLog(fmt.Sprintf("Path to folder %s", pathToMusicFolder))
 
-	var discNumber int
-	if discNumberArg == "" {
-		discNumber = 1
-	} else {
-		discNumber, err = strconv.Atoi(discNumberArg)
+	artistName := releaseArtistName(release)
+	albumName := release.Title
+	pathToAlbum := path.Join(pathToMusicFolder, artistName, sanitizeSongName(logger, albumName))
+	if _, err := os.Stat(pathToAlbum); os.IsNotExist(err) {
+		log.Printf("%s doesn't exist, creating directory\n", pathToAlbum)
+		logger.CreateLog(fmt.Sprintf("%s doesn't exist, creating directory", pathToAlbum))
+		if err = os.MkdirAll(pathToAlbum, 0777); err != nil {
+			errorMessage := fmt.Sprintf("Failed to create directory %s: %s", pathToAlbum, err)
+			return "", errors.New(errorMessage)
+		}
+	} else if err != nil {
+		// Some other error while trying to check the folder
+		errorMessage := fmt.Sprintf("Error checking directory %s: %s", pathToAlbum, err)
+		return "", errors.New(errorMessage)
+	}
+
+	return pathToAlbum, nil
+}
+
+// Scans the disc's index points and rips it into the current working directory, which is destPath
+func ripDisc(
+	options RipOptions,
+	device string,
+	readOffset int,
+	disc DiscInfo,
+	destPath string,
+	logger maokai.Logger,
+) (RipReport, TrackIndexScan, error) {
+	indexScan := TrackIndexScan{}
+	if !options.SkipIndexScan {
+		log.Println("Scanning disc for pregaps and index points")
+		var err error
+		indexScan, err = scanTrackIndexes(device, disc.TOC, logger)
 		if err != nil {
-			errorMessage := fmt.Sprintf("Failed to convert discNumberArg '%s' to int", discNumberArg)
+			errorMessage := fmt.Sprintf("Failed to scan index points, the cue sheet won't have pregaps: %s", err)
 			logger.CreateErrorLog(errorMessage)
-			log.Fatalln(errorMessage)
+			log.Println(errorMessage)
 		}
 	}
 
-	message := fmt.Sprintf("discNumber: %d", discNumber)
-	log.Println(message)
-	logger.CreateLog(message)
+	ripSettings := RipSettings{
+		Device:     device,
+		ReadOffset: readOffset,
+		SecureMode: options.Secure,
+		MaxRetries: options.Retries,
+		Image:      options.Image,
+		Progress:   newProgressReporter(disc.TOC, logger),
+	}
+	ripReport, err := RipCD(ripSettings, disc.TOC, destPath, logger)
 
-	songs := GetFlacTags(metadata, release, uint8(discNumber), logger)
+	return ripReport, indexScan, err
+}
+
+// Tags, renames and stores the tracks ripped into pathToAlbum, which must be the current working directory, then
+// writes the cue sheet and rip log and cleans up. When the tracks can't be tagged they are left as they were ripped.
+func tagRip(
+	image bool,
+	release ReleaseInfo,
+	disc DiscInfo,
+	discNumber uint8,
+	songs []FlacTags,
+	driveInfo DriveInfo,
+	ripReport RipReport,
+	indexScan TrackIndexScan,
+	pathToAlbum string,
+	logger maokai.Logger,
+) uint8 {
+	if ripReport.HiddenTrack != nil && ripReport.HiddenTrack.Ripped {
+		songs = append([]FlacTags{hiddenTrackFlacTags(*ripReport.HiddenTrack, songs)}, songs...)
+	}
 
 	for _, song := range songs {
-		message = fmt.Sprintf("song: %s\ntrack number: %d\ntags: %v\n\n", song.Title, song.TrackNumber,  song)
+		message := fmt.Sprintf("song: %s\ntrack number: %d\ntags: %v\n\n", song.Title, song.TrackNumber,  song)
 		log.Println(message)
 		logger.CreateLog(message)
 	}
 
-	for _, song := range songs {
+	// An image holds every track except the hidden one, which is still ripped into its own file
+	trackSongs := songs
+	if image {
+		trackSongs = []FlacTags{}
+		if ripReport.HiddenTrack != nil && ripReport.HiddenTrack.Ripped {
+			trackSongs = songs[:1]
+		}
+	}
+
+	trackOffset := discTrackOffset(release, discNumber)
+
+	for i, song := range trackSongs {
 		trackName := fmt.Sprintf("track%02d.flac", song.TrackNumber)
 		oldPath := path.Join(pathToAlbum, trackName)
 
@@ -136,28 +433,70 @@ func start() uint8 {
 			errorMessage := fmt.Sprintf("Failed to rename %s to %s: %s\n", oldPath, newPath, err)
 			log.Println(errorMessage)
 			logger.CreateLog(errorMessage)
-			changeDirectory(startingWorkingDirectory)
+			restoreUntaggedTracks(trackSongs[:i], trackOffset, pathToAlbum, logger)
 			return 1
 		}
 	}
 
-	AddFLACTags(songs, metadata, uint8(discNumber), release, logger)
+	cover := saveCoverArt(release, pathToAlbum, logger)
+	if err := AddFLACTags(trackSongs, trackOffset, cover, logger); err != nil {
+		errorMessage := fmt.Sprintf("Failed to tag the tracks: %s", err)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+		restoreUntaggedTracks(trackSongs, trackOffset, pathToAlbum, logger)
+		return 1
+	}
 
-	logger.CreateLog("Cleaning up folder")
-	logger.CreateLog("Deleting all *.cdda.wav files")
-	log.Printf("Deleting all *.cdda.wav files")
-	matches, err := filepath.Glob("*.cdda.wav")
-	for _, match := range matches {
-		if err := os.Remove(match); err != nil {
-			errorMessage := fmt.Sprintf("Failed to remove %s", match)
+	albumFileName := albumFileBaseName(release.Title, discNumber, release, logger)
+
+	fileNames := map[int]string{}
+	for _, song := range songs {
+		fileNames[int(song.TrackNumber)] = taggedFlacFileName(song, trackOffset, logger)
+	}
+
+	cuePath := path.Join(pathToAlbum, albumFileName+".cue")
+	cueSheet := buildCueSheet(disc, release, songs, fileNames, indexScan)
+	cueLayout := CueLayoutPerTrack
+	if image {
+		cueLayout = CueLayoutImage
+		cueSheet.ImageFileName = albumFileName + ".flac"
+
+		imagePath := path.Join(pathToAlbum, cueSheet.ImageFileName)
+		if err := writeImageFlac(IMAGE_FLAC_FILE_NAME, imagePath, cueSheet, songs[len(trackSongs):], trackOffset, cover, logger); err != nil {
+			errorMessage := fmt.Sprintf("Failed to write disc image %s: %s", imagePath, err)
 			logger.CreateErrorLog(errorMessage)
 			log.Println(errorMessage)
+			restoreUntaggedTracks(trackSongs, trackOffset, pathToAlbum, logger)
+			return 1
+		}
+
+		if err := os.Remove(IMAGE_FLAC_FILE_NAME); err != nil {
+			logger.CreateErrorLog(fmt.Sprintf("Failed to remove %s", IMAGE_FLAC_FILE_NAME))
 		}
 	}
 
+	logger.CreateLog(fmt.Sprintf("Writing cue sheet %s", cuePath))
+	if err := os.WriteFile(cuePath, []byte(cueSheet.Render(cueLayout)), 0644); err != nil {
+		errorMessage := fmt.Sprintf("Failed to write cue sheet %s: %s", cuePath, err)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+	}
+
+	ripLogPath := path.Join(pathToAlbum, albumFileName+".log")
+	ripLog := RipLog{Time: time.Now(), Drive: driveInfo, Disc: disc, Release: release, Report: ripReport}
+	logger.CreateLog(fmt.Sprintf("Writing rip log %s", ripLogPath))
+	if err := writeRipLog(ripLogPath, ripLog); err != nil {
+		errorMessage := fmt.Sprintf("Failed to write rip log %s: %s", ripLogPath, err)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+	}
+
+	logger.CreateLog("Cleaning up folder")
+	removeRippedWAVs(ripReport, image, discNumber, logger)
+
 	logger.CreateLog("Deleting all *-no-tags.flac files")
 	log.Printf("Deleting all *-no-tags.flac files")
-	matches, err = filepath.Glob("*-no-tags.flac")
+	matches, _ := filepath.Glob("*-no-tags.flac")
 	for _, match := range matches {
 		if err := os.Remove(match); err != nil {
 			logger.CreateLog(fmt.Sprintf("Failed to remove %s", match))
@@ -168,7 +507,104 @@ func start() uint8 {
 	return 0
 }
 
+// Name a wav that failed AccurateRip is kept under. It includes the disc number so the next disc of a session
+// doesn't overwrite it, and no longer ends in .cdda.wav so it isn't encoded along with the next rip
+func failedWAVFileName(wavFileName string, discNumber uint8) string {
+	return fmt.Sprintf("disc%d-%s.failed.wav", discNumber, strings.TrimSuffix(wavFileName, ".cdda.wav"))
+}
+
+// Undoes tagRip's renames after it failed part way, so the rip is left as it was ripped and can be tagged again.
+// Tagged files already saved are removed, the untagged tracks are named trackNN.flac again.
+func restoreUntaggedTracks(songs []FlacTags, trackOffset uint8, pathToAlbum string, logger maokai.Logger) {
+	for _, song := range songs {
+		taggedPath := path.Join(pathToAlbum, taggedFlacFileName(song, trackOffset, logger))
+		if err := os.Remove(taggedPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.CreateErrorLogf("Failed to remove %s: %s", taggedPath, err)
+		}
+
+		untaggedPath := path.Join(pathToAlbum,
+			fmt.Sprintf("%02d. %s-no-tags.flac", song.TrackNumber, sanitizeSongName(logger, song.Title)))
+		trackPath := path.Join(pathToAlbum, fmt.Sprintf("track%02d.flac", song.TrackNumber))
+		logger.CreateLogf("Renaming %s back to %s", untaggedPath, trackPath)
+		if err := os.Rename(untaggedPath, trackPath); err != nil {
+			errorMessage := fmt.Sprintf("Failed to rename %s back to %s: %s", untaggedPath, trackPath, err)
+			logger.CreateErrorLog(errorMessage)
+			log.Println(errorMessage)
+		}
+	}
+}
+
+// Deletes the ripped *.cdda.wav files in the current working directory, keeping those of tracks that failed
+// AccurateRip under failedWAVFileName
+func removeRippedWAVs(ripReport RipReport, image bool, discNumber uint8, logger maokai.Logger) {
+	logger.CreateLog("Deleting all *.cdda.wav files")
+	log.Printf("Deleting all *.cdda.wav files")
+	failedTracks := map[string]bool{}
+	for _, trackNumber := range ripReport.AccurateRip.FailedTracks() {
+		failedTracks[fmt.Sprintf("track%02d.cdda.wav", trackNumber)] = true
+	}
+	if image && len(failedTracks) > 0 {
+		failedTracks[IMAGE_WAV_FILE_NAME] = true
+	}
+
+	matches, _ := filepath.Glob("*.cdda.wav")
+	for _, match := range matches {
+		// Tracks that failed AccurateRip are kept so they can be compared against a re-rip
+		if failedTracks[match] {
+			keptFileName := failedWAVFileName(match, discNumber)
+			message := fmt.Sprintf("Keeping %s as %s as it failed AccurateRip verification", match, keptFileName)
+			logger.CreateLog(message)
+			log.Println(message)
+			if err := os.Rename(match, keptFileName); err != nil {
+				errorMessage := fmt.Sprintf("Failed to rename %s to %s: %s", match, keptFileName, err)
+				logger.CreateErrorLog(errorMessage)
+				log.Println(errorMessage)
+			}
+			continue
+		}
+
+		if err := os.Remove(match); err != nil {
+			errorMessage := fmt.Sprintf("Failed to remove %s", match)
+			logger.CreateErrorLog(errorMessage)
+			log.Println(errorMessage)
+		}
+	}
+}
+
 func main() {
-	code := start()
+	args := os.Args[1:]
+
+	env.LoadEnv("./.env")
+	loadConfig()
+	logger := createLogger()
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "drives":
+			os.Exit(int(runDrivesCommand(logger)))
+		case "watch":
+			os.Exit(int(runWatchCommand(args[1:], logger)))
+		case "detect-offset":
+			os.Exit(int(runDetectOffsetCommand(args[1:], logger)))
+		case "verify-log":
+			os.Exit(int(runVerifyLogCommand(args[1:], logger)))
+		case "split":
+			os.Exit(int(runSplitCommand(args[1:], logger)))
+		case "session":
+			os.Exit(int(runSessionCommand(args[1:], logger)))
+		case "tag-pending":
+			os.Exit(int(runTagPendingCommand(args[1:], logger)))
+		}
+	}
+
+	options, err := parseRipOptions(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		// The flag set has already printed the error and usage
+		os.Exit(2)
+	}
+
+	code := start(options, logger)
 	os.Exit(int(code))
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveRippedWAVsKeepsFailedTracksPerDisc(t *testing.T) {
+	directory := t.TempDir()
+	t.Chdir(directory)
+
+	for _, fileName := range []string{"track01.cdda.wav", "track02.cdda.wav", IMAGE_WAV_FILE_NAME} {
+		if err := os.WriteFile(fileName, []byte(fileName), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ripReport := RipReport{AccurateRip: AccurateRipResult{
+		InDatabase: true,
+		Tracks: []AccurateRipTrackResult{
+			{TrackNumber: 1, Confidence: 3, Version: 2},
+			{TrackNumber: 2},
+		},
+	}}
+	removeRippedWAVs(ripReport, true, 2, discardLogger{})
+
+	matches, _ := filepath.Glob("*")
+	want := []string{"disc2-image.failed.wav", "disc2-track02.failed.wav"}
+	if len(matches) != len(want) || matches[0] != want[0] || matches[1] != want[1] {
+		t.Errorf("Left %v, want %v", matches, want)
+	}
+}
@@ -1,20 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-flac/flacvorbis/v2"
 	"github.com/go-flac/go-flac/v2"
 	"github.com/mikogd/maokai"
-	"go.uploadedlobster.com/discid"
 )
 
 var (
@@ -23,17 +22,21 @@ var (
 )
 
 type Artist struct {
-	XMLName  xml.Name `xml:"artist"`
-	Name     string   `xml:"name"`
-	SortName string   `xml:"sort-name"`
-	Country  string   `xml:"country"`
-	Type     string   `xml:"type,attr"`
+	XMLName        xml.Name `xml:"artist"`
+	ID             string   `xml:"id,attr"`
+	Name           string   `xml:"name"`
+	SortName       string   `xml:"sort-name"`
+	Disambiguation string   `xml:"disambiguation"`
+	Country        string   `xml:"country"`
+	Type           string   `xml:"type,attr"`
 }
 
 type NameCredit struct {
-	XMLName    xml.Name `xml:"name-credit"`
-	Artist     Artist   `xml:"artist"`
-	JoinPhrase string   `xml:"joinphrase,attr"`
+	XMLName xml.Name `xml:"name-credit"`
+	// Name the artist is credited as on the release when it differs from theirs
+	Name       string `xml:"name"`
+	Artist     Artist `xml:"artist"`
+	JoinPhrase string `xml:"joinphrase,attr"`
 }
 
 type ArtistCredit struct {
@@ -52,16 +55,42 @@ type GenreList struct {
 	Genre   []Genre  `xml:"genre"`
 }
 
+func genreNames(genreList GenreList) []string {
+	names := make([]string, len(genreList.Genre))
+	for i, genre := range genreList.Genre {
+		names[i] = genre.Name
+	}
+
+	return names
+}
+
+type ISRC struct {
+	XMLName xml.Name `xml:"isrc"`
+	ID      string   `xml:"id,attr"`
+}
+
+type ISRCList struct {
+	XMLName xml.Name `xml:"isrc-list"`
+	ISRC    []ISRC   `xml:"isrc"`
+}
+
 type Recording struct {
 	XMLName          xml.Name     `xml:"recording"`
+	ID               string       `xml:"id,attr"`
 	Title            string       `xml:"title"`
+	Length           uint32       `xml:"length"`
+	Disambiguation   string       `xml:"disambiguation"`
 	ArtistCredit     ArtistCredit `xml:"artist-credit"`
 	FirstReleaseDate string       `xml:"first-release-date"`
 	GenreList        GenreList    `xml:"genre-list"`
+	ISRCList         ISRCList     `xml:"isrc-list"`
 }
 
 type Track struct {
-	XMLName   xml.Name  `xml:"track"`
+	XMLName xml.Name `xml:"track"`
+	ID      string   `xml:"id,attr"`
+	// Position of the track on the medium, Number is what the release prints which may not be a number e.g. A1
+	Position  uint8     `xml:"position"`
 	Number    string    `xml:"number"`
 	Length    uint32    `xml:"length"`
 	Title     string    `xml:"title"`
@@ -87,6 +116,7 @@ type DiscList struct {
 
 type Medium struct {
 	XMLName   xml.Name  `xml:"medium"`
+	Title     string    `xml:"title"`
 	TrackList TrackList `xml:"track-list"`
 	DiscList  DiscList  `xml:"disc-list"`
 	Position  uint8     `xml:"position"`
@@ -99,11 +129,66 @@ type MediumList struct {
 	Medium  []Medium `xml:"medium"`
 }
 
+type Label struct {
+	XMLName   xml.Name `xml:"label"`
+	ID        string   `xml:"id,attr"`
+	Name      string   `xml:"name"`
+	SortName  string   `xml:"sort-name"`
+	LabelCode string   `xml:"label-code"`
+}
+
+type LabelInfo struct {
+	XMLName       xml.Name `xml:"label-info"`
+	CatalogNumber string   `xml:"catalog-number"`
+	Label         Label    `xml:"label"`
+}
+
+type LabelInfoList struct {
+	XMLName   xml.Name    `xml:"label-info-list"`
+	LabelInfo []LabelInfo `xml:"label-info"`
+}
+
+type SecondaryTypeList struct {
+	XMLName       xml.Name `xml:"secondary-type-list"`
+	SecondaryType []string `xml:"secondary-type"`
+}
+
+type ReleaseGroup struct {
+	XMLName xml.Name `xml:"release-group"`
+	ID      string   `xml:"id,attr"`
+	// Primary type followed by the secondary types in older responses, use PrimaryType and SecondaryTypeList
+	Type              string            `xml:"type,attr"`
+	Title             string            `xml:"title"`
+	FirstReleaseDate  string            `xml:"first-release-date"`
+	PrimaryType       string            `xml:"primary-type"`
+	SecondaryTypeList SecondaryTypeList `xml:"secondary-type-list"`
+	ArtistCredit      ArtistCredit      `xml:"artist-credit"`
+	GenreList         GenreList         `xml:"genre-list"`
+}
+
+type TextRepresentation struct {
+	XMLName  xml.Name `xml:"text-representation"`
+	Language string   `xml:"language"`
+	Script   string   `xml:"script"`
+}
+
 type Release struct {
-	XMLName     xml.Name     `xml:"release"`
-	Title       string       `xml:"title"`
-	AristCredit ArtistCredit `xml:"artist-credit"`
-	MediumList  MediumList   `xml:"medium-list"`
+	XMLName xml.Name `xml:"release"`
+	ID      string   `xml:"id,attr"`
+	Title   string   `xml:"title"`
+	// Official, Promotion, Bootleg or Pseudo-Release
+	Status             string             `xml:"status"`
+	Disambiguation     string             `xml:"disambiguation"`
+	Packaging          string             `xml:"packaging"`
+	TextRepresentation TextRepresentation `xml:"text-representation"`
+	Date               string             `xml:"date"`
+	Country            string             `xml:"country"`
+	Barcode            string             `xml:"barcode"`
+	AristCredit        ArtistCredit       `xml:"artist-credit"`
+	ReleaseGroup       ReleaseGroup       `xml:"release-group"`
+	GenreList          GenreList          `xml:"genre-list"`
+	LabelInfoList      LabelInfoList      `xml:"label-info-list"`
+	MediumList         MediumList         `xml:"medium-list"`
 }
 
 type ReleaseList struct {
@@ -121,74 +206,180 @@ type MetaData struct {
 	XMLName  xml.Name     `xml:"metadata"`
 	Disc     *MetaDisc    `xml:"disc"`
 	Releases *ReleaseList `xml:"release-list"`
+	// Set when a single release was looked up by its ID
+	Release *Release `xml:"release"`
 }
 
-func GetMetaDataForCD(logger *maokai.FileLogger) (*MetaData, error) {
-	CDDriveName, err := getCDDriveDeviceName(logger)
-	if err != nil {
-		errorMessage := fmt.Sprintf("Failed to get CD Drive: %s", err)
-		return nil, errors.New(errorMessage)
+func creditedArtists(credit ArtistCredit) []CreditedArtist {
+	artists := make([]CreditedArtist, len(credit.NameCredit))
+	for i, nameCredit := range credit.NameCredit {
+		artists[i] = CreditedArtist{
+			Name:       nameCredit.Artist.Name,
+			JoinPhrase: nameCredit.JoinPhrase,
+			ID:         nameCredit.Artist.ID,
+			Type:       nameCredit.Artist.Type,
+		}
 	}
 
-	disc, err := discid.Read(CDDriveName)
-	if err != nil {
-		errorMessage := fmt.Sprintf("Failed to read disc ID: %s\n", err)
-		return nil, errors.New(errorMessage)
+	return artists
+}
+
+// ISRCs MusicBrainz lists for the recording
+func recordingISRCs(recording Recording) []string {
+	ISRCs := make([]string, len(recording.ISRCList.ISRC))
+	for i, ISRC := range recording.ISRCList.ISRC {
+		ISRCs[i] = strings.ToUpper(ISRC.ID)
 	}
 
-	log.Printf("Disc ID: %s\n", disc.ID())
-	logger.CreateLogf("Disc ID: %s", disc.ID())
+	return ISRCs
+}
 
-	defer disc.Close()
+// The release group's types in lower case the way Picard writes them
+func releaseGroupTypes(group ReleaseGroup) []string {
+	types := []string{}
+	if group.PrimaryType != "" {
+		types = append(types, strings.ToLower(group.PrimaryType))
+	} else if group.Type != "" {
+		types = append(types, strings.ToLower(group.Type))
+	}
 
-	URLString := fmt.Sprintf("%s/discid/%s", API_URL, disc.ID())
-	URL, err := url.Parse(URLString)
-	if err != nil {
-		errorMessage := fmt.Sprintf("Failed to parse URL %s: %s\n", URL, err)
-		return nil, errors.New(errorMessage)
+	for _, secondaryType := range group.SecondaryTypeList.SecondaryType {
+		types = append(types, strings.ToLower(secondaryType))
 	}
 
-	queries := URL.Query()
-	queries.Set("inc", "artists+recordings")
-	toc := strings.ReplaceAll(disc.TOCString(), " ", "+")
-	logger.CreateLogf("Disc TOC: %s \n", toc)
-	queries.Set("toc", toc)
-	URL.RawQuery = queries.Encode()
-	logger.CreateLogf("Creating request for URL: %s\n", URL.String())
-	req, err := http.NewRequest("GET", URL.String(), nil)
-	if err != nil {
-		errorMessage := fmt.Sprintf("Error creating request: %s\n", err)
-		return nil, errors.New(errorMessage)
+	return types
+}
+
+// Converts a release from a MusicBrainz response to the release the rest of the rip works with
+func releaseFromMusicBrainz(release Release) ReleaseInfo {
+	info := ReleaseInfo{
+		Title:          release.Title,
+		Artists:        creditedArtists(release.AristCredit),
+		Date:           release.Date,
+		Country:        release.Country,
+		Barcode:        release.Barcode,
+		Status:         release.Status,
+		Genres:         genreNames(release.GenreList),
+		Types:          releaseGroupTypes(release.ReleaseGroup),
+		ID:             release.ID,
+		ReleaseGroupID: release.ReleaseGroup.ID,
+		Source:         SOURCE_MUSICBRAINZ,
+		SourceID:       release.ID,
 	}
 
-	req.Header.Set("User-Agent", USER_AGENT)
+	if len(info.Genres) == 0 {
+		info.Genres = genreNames(release.ReleaseGroup.GenreList)
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		errorMessage := fmt.Sprintf("Error making request: %s", err)
-		return nil, errors.New(errorMessage)
+	if len(release.LabelInfoList.LabelInfo) > 0 {
+		info.Label = release.LabelInfoList.LabelInfo[0].Label.Name
+		info.CatalogNumber = release.LabelInfoList.LabelInfo[0].CatalogNumber
+	}
+
+	for _, medium := range release.MediumList.Medium {
+		mediumInfo := MediumInfo{Position: medium.Position, Format: medium.Format, Title: medium.Title}
+		for _, disc := range medium.DiscList.Disc {
+			mediumInfo.DiscIDs = append(mediumInfo.DiscIDs, disc.Id)
+		}
+
+		for _, track := range medium.TrackList.Track {
+			title := track.Title
+			if title == "" {
+				title = track.Recording.Title
+			}
+
+			mediumInfo.Tracks = append(mediumInfo.Tracks, TrackInfo{
+				Position:         track.Position,
+				Number:           track.Number,
+				Title:            title,
+				Length:           track.Length,
+				Artists:          creditedArtists(track.Recording.ArtistCredit),
+				FirstReleaseDate: track.Recording.FirstReleaseDate,
+				Genres:           genreNames(track.Recording.GenreList),
+				ISRCs:            recordingISRCs(track.Recording),
+				ID:               track.ID,
+				RecordingID:      track.Recording.ID,
+			})
+		}
+
+		info.Media = append(info.Media, mediumInfo)
+	}
+
+	return info
+}
+
+// Includes requested with every disc ID lookup, enough for the tagger to need no other lookup
+var DISC_ID_INCLUDES = []string{"artists", "recordings", "isrcs", "labels", "release-groups", "genres", "artist-credits"}
+
+// Looks the disc up on MusicBrainz, using the cache's earlier lookup while it is fresh. cache may be nil. When
+// MusicBrainz can't be reached an expired lookup is used rather than failing the rip.
+func GetMetaDataForCD(client *MusicBrainzClient, cache *MetadataCache, disc DiscInfo, logger *maokai.FileLogger) (*MetaData, error) {
+	var cached *metadataCacheEntry
+	if cache != nil {
+		var err error
+		cached, err = cache.Load(disc, DISC_ID_INCLUDES)
+		if err != nil {
+			logger.CreateErrorLogf("Failed to read cached lookup of disc %s: %s", disc.ID, err)
+		}
+
+		if cached != nil && !cache.Refresh && cache.Fresh(cached) {
+			message := fmt.Sprintf("Using lookup of disc %s cached at %s", disc.ID, cached.FetchedAt.Format(time.RFC3339))
+			log.Println(message)
+			logger.CreateLog(message)
+			return cached.MetaData, nil
+		}
 	}
 
-	defer resp.Body.Close()
+	logger.CreateLogf("Looking up disc %s with TOC %s", disc.ID, disc.TOCString)
+	metadata, response, err := client.LookupDiscID(context.Background(), disc, DISC_ID_INCLUDES)
 
-	body, err := io.ReadAll(resp.Body)
+	var notFound *MusicBrainzNotFoundError
+	if err != nil && cached != nil && !errors.As(err, &notFound) {
+		message := fmt.Sprintf("Failed to look up disc %s, using the lookup cached at %s: %s",
+			disc.ID, cached.FetchedAt.Format(time.RFC3339), err)
+		log.Println(message)
+		logger.CreateErrorLog(message)
+		return cached.MetaData, nil
+	}
 	if err != nil {
-		errorMessage := fmt.Sprintf("Error reading body: %s\n", err)
-		return nil, errors.New(errorMessage)
+		// Wrapped so callers can tell a disc MusicBrainz doesn't know with errors.As
+		return nil, fmt.Errorf("Failed to look up disc %s: %w", disc.ID, err)
 	}
 
-	metadata := MetaData{}
-	if err := xml.Unmarshal(body, &metadata); err != nil {
-		errorMessage := fmt.Sprintf("Error parsing xml: %s\n", err)
-		return nil, errors.New(errorMessage)
+	if cache != nil {
+		if err := cache.Store(disc, DISC_ID_INCLUDES, response); err != nil {
+			logger.CreateErrorLogf("Failed to cache lookup of disc %s: %s", disc.ID, err)
+		}
 	}
 
-	return &metadata, nil
+	return metadata, nil
+}
+
+var ErrNoRelease = errors.New("No CD release found")
+
+// A release the disc could be, ranked against the others
+type ReleaseCandidate struct {
+	Release ReleaseInfo
+	// Where the release came from, one of the SOURCE_ constants
+	Source string
+	// The release's ID at its source e.g. a Discogs release ID. Release.ID is only set for MusicBrainz releases.
+	SourceID string
+	// Position of the release in the providers' responses, in the order the providers were asked
+	Order int
+	// Position of the release's medium best matching the disc
+	Medium uint8
+	// How well the medium's track lengths match the disc's TOC from 0 to 1
+	Match float64
+	// How many of the disc's MCN and ISRCs the release lists
+	DiscCodeMatches int
+	// Out of 100, see scoreReleases
+	Score float64
 }
 
-func GetRelease(metadata *MetaData, logger *maokai.FileLogger) Release {
-	logger.CreateLog("Getting the release property")
+// Returns the disc's CD releases ranked best first by their score against the disc's TOC, MCN and ISRCs and the
+// release preferences. Returns ErrNoRelease when MusicBrainz has no CD release for the disc.
+func RankReleases(metadata *MetaData, disc DiscInfo, logger maokai.Logger) ([]ReleaseCandidate, error) {
+	logger.CreateLog("Ranking the disc's releases")
 	var releases []Release
 
 	if metadata.Disc != nil {
@@ -198,20 +389,36 @@ func GetRelease(metadata *MetaData, logger *maokai.FileLogger) Release {
 	} else {
 		errorMessage := fmt.Sprintf("Incomplete metadata schema can't find releases: %v\n", metadata)
 		logger.CreateLog(errorMessage)
-		log.Fatalf(errorMessage)
+		return nil, ErrNoRelease
 	}
 
+	CDReleases := []ReleaseInfo{}
 	for _, release := range releases {
-		if release.MediumList.Medium[0].Format == "CD" {
-			return release
+		for _, medium := range release.MediumList.Medium {
+			if isCDFormat(medium.Format) {
+				CDReleases = append(CDReleases, releaseFromMusicBrainz(release))
+				break
+			}
 		}
 	}
 
-	errorMessage := "No CD release found"
-	logger.CreateLog(errorMessage)
-	log.Fatalln(errorMessage)
+	candidates := scoreReleases(SOURCE_MUSICBRAINZ, CDReleases, disc, RELEASE_PREFERENCES)
+	for i := range candidates {
+		candidates[i].SourceID = candidates[i].Release.ID
+	}
+	for _, candidate := range candidates {
+		logger.CreateLogf("Release %s scores %.1f, medium %d matches the TOC %.0f%% and %d of the disc's MCN and ISRCs",
+			candidate.Release.ID, candidate.Score, candidate.Medium, candidate.Match*100, candidate.DiscCodeMatches)
+	}
+
+	if len(candidates) == 0 {
+		logger.CreateLog(ErrNoRelease.Error())
+		return nil, ErrNoRelease
+	}
+
+	sortReleaseCandidates(candidates)
 
-	return Release{}
+	return candidates, nil
 }
 
 type FlacTags struct {
@@ -228,45 +435,59 @@ type FlacTags struct {
 	Genre       []string
 	JoinPhrase  string
 	ArtistType  string
+	ISRC        string
+	// The release's UPC/EAN, the disc's MCN when it has one
+	Barcode       string
+	CatalogNumber string
+	// MusicBrainz IDs, named after the Picard tags they are written to
+	ReleaseID      string
+	ReleaseTrackID string
+	RecordingID    string
+	ArtistIDs      []string
+	AlbumArtistIDs []string
+	ReleaseGroupID string
+	DiscID         string
+	ReleaseStatus  string
+	// Primary type followed by the secondary types e.g. album, live
+	ReleaseType    []string
+	ReleaseCountry string
 }
 
-func GetFlacTags(metadata *MetaData, release Release, discNumber uint8, logger maokai.Logger) []FlacTags {
+func GetFlacTags(release ReleaseInfo, discNumber uint8, logger maokai.Logger) ([]FlacTags, error) {
 	logger.CreateLog("Getting flac tags for songs")
-	medium := release.MediumList.Medium[discNumber-1]
+	medium, found := releaseMedium(release, discNumber)
+	if !found {
+		errorMessage := fmt.Sprintf("Release %s has no disc %d", release.Title, discNumber)
+		return nil, errors.New(errorMessage)
+	}
 
-	tracks := medium.TrackList.Track
+	tracks := medium.Tracks
 	albumName := release.Title
-	trackTotal := medium.TrackList.Count
-	discTotal := len(medium.DiscList.Disc)
+	trackTotal := uint8(len(tracks))
+	discTotal := len(release.Media)
 
 	songs := make([]FlacTags, trackTotal)
 
-	albumArtist := make([]string, len(release.AristCredit.NameCredit))
-	for index, nameCredit := range release.AristCredit.NameCredit {
-		albumArtist[index] = nameCredit.Artist.Name
+	albumArtist := artistNames(release.Artists)
+
+	// Releases from other sources may credit no one
+	artistType := ""
+	if len(release.Artists) > 0 {
+		artistType = release.Artists[0].Type
 	}
 
-	artistType := release.AristCredit.NameCredit[0].Artist.Type
+	albumArtistIDs := artistIDs(release.Artists)
+
 	for i, track := range tracks {
 		tags := FlacTags{}
 
-		if track.Title != "" {
-			tags.Title = track.Title
-		} else {
-			tags.Title = track.Recording.Title
-		}
-
-		artists := make([]string, len(track.Recording.ArtistCredit.NameCredit))
-		for index, nameCredit := range track.Recording.ArtistCredit.NameCredit {
-			artists[index] = nameCredit.Artist.Name
-		}
-		tags.Artist = artists
+		tags.Title = track.Title
+		tags.Artist = artistNames(track.Artists)
 
 		trackNumber, err := strconv.Atoi(track.Number)
 		if err != nil {
-			errorMessage := fmt.Sprintf("Failed to parse track number: %v\n", track.Number)
-			logger.CreateErrorLog(errorMessage)
-			log.Fatal(errorMessage)
+			errorMessage := fmt.Sprintf("Failed to parse track number: %v", track.Number)
+			return nil, errors.New(errorMessage)
 		}
 		tags.TrackNumber = uint8(trackNumber)
 
@@ -275,26 +496,44 @@ func GetFlacTags(metadata *MetaData, release Release, discNumber uint8, logger m
 		tags.TrackTotal = trackTotal
 		tags.DiscNumber = discNumber
 		tags.DiscTotal = uint8(discTotal)
-		tags.ReleaseDate = track.Recording.FirstReleaseDate
+		tags.ReleaseDate = track.FirstReleaseDate
 
-		genres := make([]string, len(track.Recording.GenreList.Genre))
-		for index, genre := range track.Recording.GenreList.Genre {
-			genres[index] = genre.Name
+		// Tracks without genres of their own get the release's
+		tags.Genre = release.Genres
+		if len(track.Genres) > 0 {
+			tags.Genre = track.Genres
 		}
 
 		tags.Length = track.Length
 
-		if joinPhrase := track.Recording.ArtistCredit.NameCredit[0].JoinPhrase; joinPhrase != "" {
-			tags.JoinPhrase = joinPhrase
+		if len(track.Artists) > 0 {
+			tags.JoinPhrase = track.Artists[0].JoinPhrase
 		}
 
 		tags.ArtistType = artistType
+		tags.Barcode = release.Barcode
+		tags.CatalogNumber = release.CatalogNumber
+
+		tags.ReleaseID = release.ID
+		tags.ReleaseTrackID = track.ID
+		tags.RecordingID = track.RecordingID
+		tags.ArtistIDs = artistIDs(track.Artists)
+		tags.AlbumArtistIDs = albumArtistIDs
+		tags.ReleaseGroupID = release.ReleaseGroupID
+		tags.ReleaseStatus = strings.ToLower(release.Status)
+		tags.ReleaseType = release.Types
+		tags.ReleaseCountry = release.Country
+
+		// A recording can have several ISRCs, only one of them is certain to be on this disc
+		if len(track.ISRCs) == 1 {
+			tags.ISRC = track.ISRCs[0]
+		}
 
 		songs[i] = tags
 		logger.CreateLogf("Flac tags for %s: %v", track.Title, track)
 	}
 
-	return songs
+	return songs, nil
 }
 
 func ExtractFLACComment(flacFile *flac.File) (*flacvorbis.MetaDataBlockVorbisComment, int, error) {
@@ -314,62 +553,266 @@ func ExtractFLACComment(flacFile *flac.File) (*flacvorbis.MetaDataBlockVorbisCom
 	return comments, commentsIndex, nil
 }
 
-func getMediumForDiscNumber(discNumber uint8, release Release, logger maokai.Logger) Medium {
-	for _, medium := range release.MediumList.Medium {
-		if medium.Format == "CD" && medium.Position == discNumber {
-			return medium
+// Medium at the disc number's position, falling back to counting media for releases that don't list positions
+func releaseMedium(release ReleaseInfo, discNumber uint8) (MediumInfo, bool) {
+	for _, medium := range release.Media {
+		if medium.Position == discNumber {
+			return medium, true
 		}
 	}
 
-	errorMessage := fmt.Sprintf("Failed to find medium for disc number %d\n", discNumber)
-	logger.CreateErrorLog(errorMessage)
-	log.Fatalf(errorMessage)
+	if discNumber > 0 && int(discNumber) <= len(release.Media) {
+		return release.Media[discNumber-1], true
+	}
 
-	return Medium{}
+	return MediumInfo{}, false
 }
 
-func AddFLACTags(songs []FlacTags, metadata *MetaData, discNumber uint8, release Release, logger maokai.Logger) error {
-	for _, song := range songs {
-		fileNameWithoutTags := fmt.Sprintf("%02d. %s-no-tags.flac", song.TrackNumber, sanitizeSongName(logger, song.Title))
-		flacFile, err := flac.ParseFile(fileNameWithoutTags)
-		if err != nil {
-			return err
+// Position of the release's medium listing the disc's ID, false when no medium lists it
+func detectDiscNumber(release ReleaseInfo, disc DiscInfo) (uint8, bool) {
+	for i, medium := range release.Media {
+		if !slices.Contains(medium.DiscIDs, disc.ID) {
+			continue
 		}
 
-		comments, commentsIndex, err := ExtractFLACComment(flacFile)
-		if err != nil {
-			return err
+		if medium.Position == 0 {
+			return uint8(i + 1), true
+		}
+		return medium.Position, true
+	}
+
+	return 0, false
+}
+
+// Works out which of the release's discs is in the drive. The medium listing the disc ID wins, then the override
+// given on the command line, then the medium whose track lengths match the TOC. An override disagreeing with the disc
+// ID is used but warned about.
+func resolveDiscNumber(release ReleaseInfo, disc DiscInfo, override uint8, logger maokai.Logger) uint8 {
+	detected, found := detectDiscNumber(release, disc)
+
+	switch {
+	case found && override != 0 && override != detected:
+		message := fmt.Sprintf("Disc %s is disc %d of %s but disc %d was given, tagging it as disc %d", disc.ID,
+			detected, release.Title, override, override)
+		log.Printf("Warning: %s\n", message)
+		logger.CreateErrorLog(message)
+		return override
+	case override != 0:
+		return override
+	case found:
+		logger.CreateLogf("Disc %s is disc %d of %s", disc.ID, detected, release.Title)
+		return detected
+	}
+
+	if medium, match := bestMediumMatch(release, disc); match*100 >= RELEASE_MATCH_THRESHOLD && medium.Position != 0 {
+		logger.CreateLogf("No medium of %s lists disc %s, disc %d matches its track lengths %.0f%%", release.Title,
+			disc.ID, medium.Position, match*100)
+		return medium.Position
+	}
+
+	if len(release.Media) > 1 {
+		message := fmt.Sprintf("Can't tell which disc of %s is in the drive, tagging it as disc 1. Pass the disc "+
+			"number if it's another disc", release.Title)
+		log.Printf("Warning: %s\n", message)
+		logger.CreateErrorLog(message)
+	}
+
+	return 1
+}
+
+// Name of the song's tagged flac file, numbered after the trackOffset tracks of the release's earlier discs
+func taggedFlacFileName(song FlacTags, trackOffset uint8, logger maokai.Logger) string {
+	// The hidden track plays before the disc's first track so it isn't numbered after the earlier discs' tracks. It
+	// is prefixed with the disc number on later discs instead so their hidden tracks don't overwrite disc 1's
+	if song.TrackNumber == 0 {
+		if song.DiscNumber > 1 {
+			return fmt.Sprintf("%d-00. %s.flac", song.DiscNumber, sanitizeSongName(logger, song.Title))
 		}
 
-		if comments == nil && commentsIndex > 0 {
-			comments = flacvorbis.New()
+		return fmt.Sprintf("00. %s.flac", sanitizeSongName(logger, song.Title))
+	}
+
+	return fmt.Sprintf("%02d. %s.flac", trackOffset+song.TrackNumber, sanitizeSongName(logger, song.Title))
+}
+
+// Number of tracks on the release's CDs before the disc, its tracks' files are numbered after them
+func discTrackOffset(release ReleaseInfo, discNumber uint8) uint8 {
+	var trackOffset uint8 = 0
+	for _, medium := range release.Media {
+		if isCDFormat(medium.Format) && medium.Position < discNumber {
+			trackOffset += uint8(len(medium.Tracks))
 		}
+	}
+
+	return trackOffset
+}
+
+// A Vorbis comment field
+type flacTagField struct {
+	Name  string
+	Value string
+}
+
+// The Vorbis comment fields a song is tagged with
+func flacTagFields(song FlacTags) []flacTagField {
+	fields := []flacTagField{{"TITLE", song.Title}}
+
+	for _, artist := range song.Artist {
+		fields = append(fields, flacTagField{"ARTIST", artist})
+	}
 
-		comments.Add("TITLE", song.Title)
+	fields = append(fields, flacTagField{"ALBUM", song.Album})
+
+	for _, albumArtist := range song.AlbumArtist {
+		fields = append(fields, flacTagField{"ALBUMARTIST", albumArtist})
+	}
+
+	fields = append(fields,
+		flacTagField{"TRACKNUMBER", strconv.Itoa(int(song.TrackNumber))},
+		flacTagField{"TRACKTOTAL", strconv.Itoa(int(song.TrackTotal))},
+		flacTagField{"DISCNUMBER", strconv.Itoa(int(song.DiscNumber))},
+		flacTagField{"DISCTOTAL", strconv.Itoa(int(song.DiscTotal))},
+		flacTagField{"RELEASEDATE", song.ReleaseDate},
+		flacTagField{"LENGTH", strconv.Itoa(int(song.Length))},
+	)
+
+	for _, genre := range song.Genre {
+		fields = append(fields, flacTagField{"GENRE", genre})
+	}
+
+	fields = append(fields,
+		flacTagField{"JOINPHRASE", song.JoinPhrase},
+		flacTagField{"ARTISTTYPE", song.ArtistType},
+	)
+
+	// Tags that are often unknown are left out rather than written empty. The MusicBrainz tags use Picard's names so
+	// Picard, beets and the like can link the files back to MusicBrainz.
+	optionalFields := []flacTagField{
+		{"ISRC", song.ISRC},
+		{"BARCODE", song.Barcode},
+		{"CATALOGNUMBER", song.CatalogNumber},
+		{"MUSICBRAINZ_ALBUMID", song.ReleaseID},
+		{"MUSICBRAINZ_RELEASETRACKID", song.ReleaseTrackID},
+		{"MUSICBRAINZ_TRACKID", song.RecordingID},
+		{"MUSICBRAINZ_RELEASEGROUPID", song.ReleaseGroupID},
+		{"MUSICBRAINZ_DISCID", song.DiscID},
+		{"RELEASESTATUS", song.ReleaseStatus},
+		{"RELEASECOUNTRY", song.ReleaseCountry},
+	}
+	for _, artistID := range song.ArtistIDs {
+		optionalFields = append(optionalFields, flacTagField{"MUSICBRAINZ_ARTISTID", artistID})
+	}
+	for _, albumArtistID := range song.AlbumArtistIDs {
+		optionalFields = append(optionalFields, flacTagField{"MUSICBRAINZ_ALBUMARTISTID", albumArtistID})
+	}
+	for _, releaseType := range song.ReleaseType {
+		optionalFields = append(optionalFields, flacTagField{"RELEASETYPE", releaseType})
+	}
 
-		for _, artist := range song.Artist {
-			comments.Add("ARTIST", artist)
+	for _, field := range optionalFields {
+		if field.Value != "" {
+			fields = append(fields, field)
 		}
+	}
 
-		comments.Add("ALBUM", song.Album)
+	return fields
+}
 
-		for _, albumArtist := range song.AlbumArtist {
-			comments.Add("ALBUMARTIST", albumArtist)
+// Rebuilds a song's tags from the fields flacTagFields returned, unknown fields are ignored
+func flacTagsFromFields(fields []flacTagField) FlacTags {
+	song := FlacTags{}
+	parseUint8 := func(value string) uint8 {
+		number, _ := strconv.Atoi(value)
+		return uint8(number)
+	}
+
+	for _, field := range fields {
+		switch field.Name {
+		case "TITLE":
+			song.Title = field.Value
+		case "ARTIST":
+			song.Artist = append(song.Artist, field.Value)
+		case "ALBUM":
+			song.Album = field.Value
+		case "ALBUMARTIST":
+			song.AlbumArtist = append(song.AlbumArtist, field.Value)
+		case "TRACKNUMBER":
+			song.TrackNumber = parseUint8(field.Value)
+		case "TRACKTOTAL":
+			song.TrackTotal = parseUint8(field.Value)
+		case "DISCNUMBER":
+			song.DiscNumber = parseUint8(field.Value)
+		case "DISCTOTAL":
+			song.DiscTotal = parseUint8(field.Value)
+		case "RELEASEDATE":
+			song.ReleaseDate = field.Value
+		case "LENGTH":
+			length, _ := strconv.ParseUint(field.Value, 10, 32)
+			song.Length = uint32(length)
+		case "GENRE":
+			song.Genre = append(song.Genre, field.Value)
+		case "JOINPHRASE":
+			song.JoinPhrase = field.Value
+		case "ARTISTTYPE":
+			song.ArtistType = field.Value
+		case "ISRC":
+			song.ISRC = field.Value
+		case "BARCODE":
+			song.Barcode = field.Value
+		case "CATALOGNUMBER":
+			song.CatalogNumber = field.Value
+		case "MUSICBRAINZ_ALBUMID":
+			song.ReleaseID = field.Value
+		case "MUSICBRAINZ_RELEASETRACKID":
+			song.ReleaseTrackID = field.Value
+		case "MUSICBRAINZ_TRACKID":
+			song.RecordingID = field.Value
+		case "MUSICBRAINZ_ARTISTID":
+			song.ArtistIDs = append(song.ArtistIDs, field.Value)
+		case "MUSICBRAINZ_ALBUMARTISTID":
+			song.AlbumArtistIDs = append(song.AlbumArtistIDs, field.Value)
+		case "MUSICBRAINZ_RELEASEGROUPID":
+			song.ReleaseGroupID = field.Value
+		case "MUSICBRAINZ_DISCID":
+			song.DiscID = field.Value
+		case "RELEASESTATUS":
+			song.ReleaseStatus = field.Value
+		case "RELEASETYPE":
+			song.ReleaseType = append(song.ReleaseType, field.Value)
+		case "RELEASECOUNTRY":
+			song.ReleaseCountry = field.Value
 		}
+	}
 
-		comments.Add("TRACKNUMBER", strconv.Itoa(int(song.TrackNumber)))
-		comments.Add("TRACKTOTAL", strconv.Itoa(int(song.TrackTotal)))
-		comments.Add("DISCNUMBER", strconv.Itoa(int(song.DiscNumber)))
-		comments.Add("DISCTOTAL", strconv.Itoa(int(song.DiscTotal)))
-		comments.Add("RELEASEDATE", song.ReleaseDate)
-		comments.Add("LENGTH", strconv.Itoa(int(song.Length)))
+	return song
+}
+
+// Tags the songs' -no-tags files and saves them under their final names, numbered after the trackOffset tracks of the
+// earlier discs. Embeds cover when it isn't nil.
+func AddFLACTags(
+	songs []FlacTags,
+	trackOffset uint8,
+	cover *CoverArt,
+	logger maokai.Logger,
+) error {
+	for _, song := range songs {
+		fileNameWithoutTags := fmt.Sprintf("%02d. %s-no-tags.flac", song.TrackNumber, sanitizeSongName(logger, song.Title))
+		flacFile, err := flac.ParseFile(fileNameWithoutTags)
+		if err != nil {
+			return err
+		}
 
-		for _, genre := range song.Genre {
-			comments.Add("GENRE", genre)
+		_, commentsIndex, err := ExtractFLACComment(flacFile)
+		if err != nil {
+			return err
 		}
 
-		comments.Add("JOINPHRASE", song.JoinPhrase)
-		comments.Add("ARTISTTYPE", song.ArtistType)
+		// Start from empty comments so fields the file already has, like an image's when splitting it, aren't
+		// repeated
+		comments := flacvorbis.New()
+		for _, field := range flacTagFields(song) {
+			comments.Add(field.Name, field.Value)
+		}
 
 		commentsMeta := comments.Marshal()
 
@@ -378,15 +821,9 @@ func AddFLACTags(songs []FlacTags, metadata *MetaData, discNumber uint8, release
 		} else {
 			flacFile.Meta = append(flacFile.Meta, &commentsMeta)
 		}
+		setFlacCover(flacFile, cover)
 
-		var currentTrackNumber uint8 = 0
-		for i := 1; i < int(discNumber); i++ {
-			medium := getMediumForDiscNumber(uint8(i+1), release, logger)
-			currentTrackNumber += medium.TrackList.Count
-		}
-
-		flacFileWithTagsName := fmt.Sprintf("%02d. %s.flac",
-			currentTrackNumber+song.TrackNumber, sanitizeSongName(logger, song.Title))
+		flacFileWithTagsName := taggedFlacFileName(song, trackOffset, logger)
 
 		logger.CreateLog(fmt.Sprintf("Saving tags for %s", flacFileWithTagsName))
 		if err := flacFile.Save(flacFileWithTagsName); err != nil {
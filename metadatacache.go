@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/mikogd/maokai"
+)
+
+// How long a cached lookup is used before MusicBrainz is asked again, set with METADATA_CACHE_TTL
+const DEFAULT_METADATA_CACHE_TTL = 30 * 24 * time.Hour
+
+// A disc ID lookup as it was stored in the cache
+type metadataCacheEntry struct {
+	FetchedAt time.Time
+	DiscID    string
+	TOC       string
+	Includes  []string
+	// The XML MusicBrainz returned, parsed again on every load so cached lookups pick up changes to the model
+	Response string
+	// Parsed from Response by Load
+	MetaData *MetaData `json:"-"`
+}
+
+// Disc ID lookups stored on disk so discs can be ripped again without asking MusicBrainz, or while offline
+type MetadataCache struct {
+	Directory string
+	TTL       time.Duration
+	// Ask MusicBrainz even when the cache has a fresh lookup, the new lookup is still stored
+	Refresh bool
+	Logger  maokai.Logger
+}
+
+// Directory the cache is kept in. Set with METADATA_CACHE_DIR, defaults to $XDG_CACHE_HOME/sona/musicbrainz.
+func metadataCacheDirectory() (string, error) {
+	if cacheDirectory := os.Getenv("METADATA_CACHE_DIR"); cacheDirectory != "" {
+		return cacheDirectory, nil
+	}
+
+	cacheDirectory, err := os.UserCacheDir()
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to get cache directory: %s", err)
+		return "", errors.New(errorMessage)
+	}
+
+	return filepath.Join(cacheDirectory, "sona", "musicbrainz"), nil
+}
+
+func NewMetadataCache(refresh bool, logger maokai.Logger) (*MetadataCache, error) {
+	directory, err := metadataCacheDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	TTL := DEFAULT_METADATA_CACHE_TTL
+	if TTLString := os.Getenv("METADATA_CACHE_TTL"); TTLString != "" {
+		TTL, err = time.ParseDuration(TTLString)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Failed to parse METADATA_CACHE_TTL %s: %s", TTLString, err)
+			return nil, errors.New(errorMessage)
+		}
+	}
+
+	return &MetadataCache{Directory: directory, TTL: TTL, Refresh: refresh, Logger: logger}, nil
+}
+
+// File a disc's lookup is stored in. The TOC is part of the name as MusicBrainz's answer depends on it when it
+// doesn't know the disc ID.
+func (c *MetadataCache) path(disc DiscInfo) string {
+	TOCHash := sha256.Sum256([]byte(disc.TOCString))
+	return filepath.Join(c.Directory, fmt.Sprintf("%s-%x.json", disc.ID, TOCHash[:8]))
+}
+
+// Returns the cached lookup of the disc made with the same includes, nil when there is none. The entry may have
+// expired, check it with Fresh.
+func (c *MetadataCache) Load(disc DiscInfo, includes []string) (*metadataCacheEntry, error) {
+	data, err := os.ReadFile(c.path(disc))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	entry := metadataCacheEntry{}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		errorMessage := fmt.Sprintf("Failed to parse cached lookup %s: %s", c.path(disc), err)
+		return nil, errors.New(errorMessage)
+	}
+
+	// A lookup made with other includes is missing data or has data we no longer ask for
+	if entry.DiscID != disc.ID || entry.TOC != disc.TOCString || !slices.Equal(entry.Includes, includes) ||
+		entry.Response == "" {
+		return nil, nil
+	}
+
+	metadata := MetaData{}
+	if err := xml.Unmarshal([]byte(entry.Response), &metadata); err != nil {
+		errorMessage := fmt.Sprintf("Failed to parse the response cached in %s: %s", c.path(disc), err)
+		return nil, errors.New(errorMessage)
+	}
+	entry.MetaData = &metadata
+
+	return &entry, nil
+}
+
+func (c *MetadataCache) Fresh(entry *metadataCacheEntry) bool {
+	return time.Since(entry.FetchedAt) < c.TTL
+}
+
+func (c *MetadataCache) Store(disc DiscInfo, includes []string, response []byte) error {
+	entry := metadataCacheEntry{
+		FetchedAt: time.Now(),
+		DiscID:    disc.ID,
+		TOC:       disc.TOCString,
+		Includes:  includes,
+		Response:  string(response),
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.Directory, 0755); err != nil {
+		return err
+	}
+
+	c.Logger.CreateLogf("Caching lookup of disc %s in %s", disc.ID, c.path(disc))
+	return os.WriteFile(c.path(disc), data, 0644)
+}
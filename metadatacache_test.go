@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMetadataCacheParsesStoredResponse(t *testing.T) {
+	cache := &MetadataCache{Directory: t.TempDir(), TTL: time.Hour, Logger: discardLogger{}}
+	disc := DiscInfo{ID: "Wn8eRBtfLDfM0qjYPdxrz.Zjs_U-", TOCString: "1 1 20000 150"}
+	includes := []string{"recordings"}
+
+	if err := cache.Store(disc, includes, []byte(testDiscIDResponse)); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	entry, err := cache.Load(disc, includes)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if entry == nil || entry.MetaData == nil || entry.MetaData.Disc == nil {
+		t.Fatalf("Load returned %+v, want the stored lookup", entry)
+	}
+
+	releases := entry.MetaData.Disc.Releases.Release
+	if len(releases) != 1 || releases[0].Title != "Album" || releases[0].Status != "Official" {
+		t.Errorf("Load parsed releases %+v, want the stored release", releases)
+	}
+
+	if entry, _ := cache.Load(disc, []string{"recordings", "isrcs"}); entry != nil {
+		t.Error("Load returned a lookup made with other includes")
+	}
+}
+
+func TestMetadataCacheRejectsBrokenResponse(t *testing.T) {
+	cache := &MetadataCache{Directory: t.TempDir(), TTL: time.Hour, Logger: discardLogger{}}
+	disc := DiscInfo{ID: "Wn8eRBtfLDfM0qjYPdxrz.Zjs_U-", TOCString: "1 1 20000 150"}
+
+	if err := cache.Store(disc, nil, []byte("<metadata><disc>")); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if _, err := os.Stat(cache.path(disc)); err != nil {
+		t.Fatalf("nothing stored: %v", err)
+	}
+
+	if _, err := cache.Load(disc, nil); err == nil {
+		t.Error("Load of an unparsable response didn't fail")
+	}
+}
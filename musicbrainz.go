@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mikogd/maokai"
+)
+
+const (
+	// MusicBrainz allows a single request a second from each client
+	MUSICBRAINZ_RATE_LIMIT = time.Second
+	// Longest a single request may take before it is abandoned
+	MUSICBRAINZ_TIMEOUT = 30 * time.Second
+	// Retries of a request MusicBrainz answered with 503 Service Unavailable
+	MUSICBRAINZ_MAX_RETRIES = 4
+)
+
+// Returned when MusicBrainz has nothing at the requested path e.g. an unknown disc ID
+type MusicBrainzNotFoundError struct {
+	URL string
+}
+
+func (e *MusicBrainzNotFoundError) Error() string {
+	return fmt.Sprintf("MusicBrainz has nothing at %s", e.URL)
+}
+
+// Returned for any other response that isn't a 200 OK
+type MusicBrainzStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *MusicBrainzStatusError) Error() string {
+	return fmt.Sprintf("MusicBrainz request %s failed: %s", e.URL, e.Status)
+}
+
+// Client for the MusicBrainz XML web service keeping to its rate limit. Safe to share between goroutines.
+type MusicBrainzClient struct {
+	// Web service root e.g. https://musicbrainz.org/ws/2
+	BaseURL    string
+	UserAgent  string
+	HTTPClient *http.Client
+	// Minimum time between the start of two requests
+	RateLimit  time.Duration
+	Timeout    time.Duration
+	MaxRetries int
+	Logger     maokai.Logger
+
+	mutex       sync.Mutex
+	lastRequest time.Time
+}
+
+func NewMusicBrainzClient(baseURL string, logger maokai.Logger) *MusicBrainzClient {
+	return &MusicBrainzClient{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		UserAgent:  USER_AGENT,
+		HTTPClient: &http.Client{},
+		RateLimit:  MUSICBRAINZ_RATE_LIMIT,
+		Timeout:    MUSICBRAINZ_TIMEOUT,
+		MaxRetries: MUSICBRAINZ_MAX_RETRIES,
+		Logger:     logger,
+	}
+}
+
+// Blocks until the rate limit allows another request
+func (c *MusicBrainzClient) waitForRateLimit(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if wait := time.Until(c.lastRequest.Add(c.RateLimit)); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	c.lastRequest = time.Now()
+
+	return nil
+}
+
+// How long to wait before retrying a 503, the server's Retry-After when it sends one
+func retryDelay(response *http.Response, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	return time.Duration(1<<attempt) * time.Second
+}
+
+// Sends a single GET returning the body of a 200 OK, or the response for a 503 so it can be retried
+func (c *MusicBrainzClient) getOnce(ctx context.Context, requestURL string) ([]byte, *http.Response, error) {
+	if err := c.waitForRateLimit(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	requestCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(requestCtx, "GET", requestURL, nil)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error creating request: %s", err)
+		return nil, nil, errors.New(errorMessage)
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Accept", "application/xml")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error making request: %s", err)
+		return nil, nil, errors.New(errorMessage)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusServiceUnavailable:
+		return nil, resp, nil
+	case http.StatusNotFound:
+		return nil, nil, &MusicBrainzNotFoundError{URL: requestURL}
+	default:
+		return nil, nil, &MusicBrainzStatusError{URL: requestURL, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error reading body: %s", err)
+		return nil, nil, errors.New(errorMessage)
+	}
+
+	return body, nil, nil
+}
+
+// Requests path under the base URL returning the XML response, retrying with a back off while MusicBrainz answers 503
+func (c *MusicBrainzClient) GetRaw(ctx context.Context, path string, query url.Values) ([]byte, error) {
+	requestURL := c.BaseURL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		c.Logger.CreateLogf("Requesting %s", requestURL)
+		body, unavailable, err := c.getOnce(ctx, requestURL)
+		if err != nil {
+			return nil, err
+		}
+
+		if unavailable == nil {
+			return body, nil
+		}
+
+		if attempt >= c.MaxRetries {
+			return nil, &MusicBrainzStatusError{URL: requestURL, StatusCode: unavailable.StatusCode, Status: unavailable.Status}
+		}
+
+		delay := retryDelay(unavailable, attempt)
+		c.Logger.CreateLogf("MusicBrainz is rate limiting, retrying in %s", delay)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Requests path under the base URL and decodes the XML response into result
+func (c *MusicBrainzClient) Get(ctx context.Context, path string, query url.Values, result any) error {
+	body, err := c.GetRaw(ctx, path, query)
+	if err != nil {
+		return err
+	}
+
+	if err := xml.Unmarshal(body, result); err != nil {
+		errorMessage := fmt.Sprintf("Error parsing xml: %s", err)
+		return errors.New(errorMessage)
+	}
+
+	return nil
+}
+
+// Looks up the releases holding a disc, returning them along with the XML they were parsed from. The TOC lets
+// MusicBrainz fall back to releases with a matching track layout when it doesn't know the disc ID.
+func (c *MusicBrainzClient) LookupDiscID(ctx context.Context, disc DiscInfo, includes []string) (*MetaData, []byte, error) {
+	query := url.Values{}
+	query.Set("inc", strings.Join(includes, "+"))
+	query.Set("toc", strings.ReplaceAll(disc.TOCString, " ", "+"))
+
+	body, err := c.GetRaw(ctx, "/discid/"+url.PathEscape(disc.ID), query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metadata := MetaData{}
+	if err := xml.Unmarshal(body, &metadata); err != nil {
+		errorMessage := fmt.Sprintf("Error parsing xml: %s", err)
+		return nil, nil, errors.New(errorMessage)
+	}
+
+	return &metadata, body, nil
+}
+
+// Looks up a single release by its MBID
+func (c *MusicBrainzClient) LookupRelease(ctx context.Context, releaseID string, includes []string) (*Release, error) {
+	query := url.Values{}
+	query.Set("inc", strings.Join(includes, "+"))
+
+	metadata := MetaData{}
+	if err := c.Get(ctx, "/release/"+url.PathEscape(releaseID), query, &metadata); err != nil {
+		return nil, err
+	}
+
+	if metadata.Release == nil {
+		errorMessage := fmt.Sprintf("MusicBrainz returned no release for %s", releaseID)
+		return nil, errors.New(errorMessage)
+	}
+
+	return metadata.Release, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+const testDiscIDResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://musicbrainz.org/ns/mmd-2.0#">
+  <disc id="Wn8eRBtfLDfM0qjYPdxrz.Zjs_U-">
+    <release-list count="1">
+      <release id="b84ee12a-09ef-421b-82de-0441a926375b">
+        <title>Album</title>
+        <status>Official</status>
+      </release>
+    </release-list>
+  </disc>
+</metadata>`
+
+func newTestMusicBrainzClient(serverURL string) *MusicBrainzClient {
+	client := NewMusicBrainzClient(serverURL, discardLogger{})
+	client.RateLimit = 0
+	return client
+}
+
+var testDisc = DiscInfo{ID: "Wn8eRBtfLDfM0qjYPdxrz.Zjs_U-", TOCString: "1 1 20000 150"}
+
+func TestLookupDiscIDRetriesUnavailable(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/discid/"+testDisc.ID {
+			t.Errorf("requested %s", r.URL.Path)
+		}
+		if r.Header.Get("User-Agent") == "" {
+			t.Error("request has no User-Agent")
+		}
+
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(testDiscIDResponse))
+	}))
+	defer server.Close()
+
+	client := newTestMusicBrainzClient(server.URL)
+	metadata, body, err := client.LookupDiscID(context.Background(), testDisc, []string{"recordings"})
+	if err != nil {
+		t.Fatalf("LookupDiscID: %v", err)
+	}
+
+	if requests.Load() != 2 {
+		t.Errorf("made %d requests, want 2", requests.Load())
+	}
+	if string(body) != testDiscIDResponse {
+		t.Error("LookupDiscID didn't return the response body")
+	}
+	if metadata.Disc == nil || len(metadata.Disc.Releases.Release) != 1 {
+		t.Errorf("LookupDiscID parsed %+v, want one release", metadata.Disc)
+	}
+}
+
+func TestLookupDiscIDGivesUpWhileUnavailable(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := newTestMusicBrainzClient(server.URL)
+	client.MaxRetries = 0
+	_, _, err := client.LookupDiscID(context.Background(), testDisc, nil)
+
+	var statusErr *MusicBrainzStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("LookupDiscID returned %v, want a 503 MusicBrainzStatusError", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("made %d requests, want 1", requests.Load())
+	}
+}
+
+func TestLookupDiscIDNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, _, err := newTestMusicBrainzClient(server.URL).LookupDiscID(context.Background(), testDisc, nil)
+
+	var notFound *MusicBrainzNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Errorf("LookupDiscID returned %v, want a MusicBrainzNotFoundError", err)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"github.com/mikogd/maokai"
+)
+
+// AccurateRip only tolerates offsets within the sectors it skips at the start and end of the disc
+const MAX_READ_OFFSET = ACCURATERIP_SKIPPED_SECTORS * SAMPLES_PER_SECTOR
+
+// Path to the JSON file mapping "<ID_VENDOR> <ID_MODEL>" to the drive's read offset in samples. Set with
+// DRIVE_OFFSETS_PATH, defaults to $XDG_CONFIG_HOME/sona/drive-offsets.json.
+func driveOffsetsPath() (string, error) {
+	if offsetsPath := os.Getenv("DRIVE_OFFSETS_PATH"); offsetsPath != "" {
+		return offsetsPath, nil
+	}
+
+	configDirectory, err := os.UserConfigDir()
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to get config directory: %s", err)
+		return "", errors.New(errorMessage)
+	}
+
+	return filepath.Join(configDirectory, "sona", "drive-offsets.json"), nil
+}
+
+// Key a drive's offset is stored under, using the vendor and model udev reports
+func driveOffsetKey(info DriveInfo) string {
+	return fmt.Sprintf("%s %s", info.Vendor, info.Model)
+}
+
+func loadDriveOffsets() (map[string]int, error) {
+	offsetsPath, err := driveOffsetsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := map[string]int{}
+	data, err := os.ReadFile(offsetsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return offsets, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &offsets); err != nil {
+		errorMessage := fmt.Sprintf("Failed to parse drive offsets %s: %s", offsetsPath, err)
+		return nil, errors.New(errorMessage)
+	}
+
+	return offsets, nil
+}
+
+func saveDriveOffset(key string, offset int) error {
+	offsets, err := loadDriveOffsets()
+	if err != nil {
+		return err
+	}
+	offsets[key] = offset
+
+	offsetsPath, err := driveOffsetsPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(offsetsPath), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(offsets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(offsetsPath, data, 0644)
+}
+
+// Returns the configured read offset for the drive, 0 when none is configured
+func getDriveOffset(info DriveInfo, logger maokai.Logger) (int, error) {
+	offsets, err := loadDriveOffsets()
+	if err != nil {
+		return 0, err
+	}
+
+	key := driveOffsetKey(info)
+	offset, found := offsets[key]
+	if !found {
+		message := fmt.Sprintf("No read offset configured for \"%s\", ripping without offset correction", key)
+		log.Println(message)
+		logger.CreateLog(message)
+		return 0, nil
+	}
+
+	logger.CreateLogf("Using read offset %+d for \"%s\"", offset, key)
+
+	return offset, nil
+}
+
+// Finds the offsets whose AccurateRip v1 or v2 checksum for the track matches one of the CRCs. samples holds the track
+// surrounded by the tracks before and after it and trackStart is where the track starts without any offset.
+func findMatchingOffsets(samples []uint32, trackStart int, trackLength int, maxOffset int, CRCs map[uint32]int) map[int]int {
+	matches := map[int]int{}
+
+	startOffset := max(-maxOffset, -trackStart)
+	endOffset := min(maxOffset, len(samples)-trackStart-trackLength)
+	if startOffset > endOffset {
+		return matches
+	}
+
+	// Compute the checksum at the first offset then slide the window one sample at a time. With
+	// sum = s[i]*1 + s[i+1]*2 + ... moving the window along subtracts the plain sum of the window and adds the
+	// sample entering it times the track length.
+	window := samples[trackStart+startOffset : trackStart+startOffset+trackLength]
+	var CRC, sum uint32
+	for i, sample := range window {
+		CRC += sample * uint32(i+1)
+		sum += sample
+	}
+
+	for offset := startOffset; ; offset++ {
+		if confidence, found := CRCs[CRC]; found {
+			matches[offset] = confidence
+		}
+
+		if offset == endOffset {
+			break
+		}
+
+		leaving := samples[trackStart+offset]
+		entering := samples[trackStart+offset+trackLength]
+		CRC = CRC - sum + entering*uint32(trackLength)
+		sum = sum - leaving + entering
+	}
+
+	// The v2 checksum can't be slid along, so it is only computed at the offsets v1 matched. When none did, e.g. for
+	// a disc with only v2 submissions, it is computed at every offset which takes a while
+	offsets := []int{}
+	for offset := range matches {
+		offsets = append(offsets, offset)
+	}
+	if len(offsets) == 0 {
+		for offset := startOffset; offset <= endOffset; offset++ {
+			offsets = append(offsets, offset)
+		}
+	}
+
+	for _, offset := range offsets {
+		CRCv1, CRCv2 := computeAccurateRipCRCs(samples[trackStart+offset:trackStart+offset+trackLength], false, false)
+		if CRCv2 == CRCv1 {
+			continue
+		}
+
+		if confidence, found := CRCs[CRCv2]; found {
+			matches[offset] += confidence
+		}
+	}
+
+	return matches
+}
+
+// Works out the drive's read offset by ripping a track of a disc in the AccurateRip database together with its
+// neighbours and finding the shift that makes the track match the database
+func detectDriveOffset(device string, disc DiscInfo, trackNumber int, logger maokai.Logger) (map[int]int, error) {
+	toc := disc.TOC
+	if trackNumber <= toc.FirstTrack || trackNumber >= toc.LastTrack {
+		errorMessage := fmt.Sprintf("Track %d needs a track before and after it, the disc has tracks %d to %d",
+			trackNumber, toc.FirstTrack, toc.LastTrack)
+		return nil, errors.New(errorMessage)
+	}
+
+	discID := computeAccurateRipDiscID(toc)
+	response, err := fetchAccurateRip(discID, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Pressings) == 0 {
+		return nil, errors.New("Disc is not in the AccurateRip database, use another disc")
+	}
+
+	CRCs := map[uint32]int{}
+	for _, pressing := range response.Pressings {
+		entry := pressing[trackNumber-toc.FirstTrack]
+		CRCs[entry.CRC] += int(entry.Confidence)
+	}
+
+	tempDirectory, err := os.MkdirTemp("", "sona-offset-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDirectory)
+
+	fileName := filepath.Join(tempDirectory, "tracks.wav")
+	span := fmt.Sprintf("%d-%d", trackNumber-1, trackNumber+1)
+	logger.CreateLogf("Running command cdparanoia -d %s -w %s %s", device, span, fileName)
+	cmd := exec.Command("cdparanoia", "-d", device, "-w", span, fileName)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		errorMessage := fmt.Sprintf("Failed to run cdparanoia -w %s: %s", span, err)
+		return nil, errors.New(errorMessage)
+	}
+
+	samples, err := readWAVSamples(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	trackStart := toc.TrackSectors(trackNumber-1) * SAMPLES_PER_SECTOR
+	trackLength := toc.TrackSectors(trackNumber) * SAMPLES_PER_SECTOR
+
+	return findMatchingOffsets(samples, trackStart, trackLength, MAX_READ_OFFSET, CRCs), nil
+}
+
+// Sorts the matched offsets best first by confidence. Ties go to the offset closest to 0, then to the negative one, so
+// the same rip always gives the same offset.
+func rankOffsets(matches map[int]int) []int {
+	offsets := make([]int, 0, len(matches))
+	for offset := range matches {
+		offsets = append(offsets, offset)
+	}
+
+	distance := func(offset int) int { return max(offset, -offset) }
+	sort.Slice(offsets, func(i, j int) bool {
+		a, b := offsets[i], offsets[j]
+		if matches[a] != matches[b] {
+			return matches[a] > matches[b]
+		}
+		if distance(a) != distance(b) {
+			return distance(a) < distance(b)
+		}
+		return a < b
+	})
+
+	return offsets
+}
+
+func runDetectOffsetCommand(args []string, logger *maokai.FileLogger) uint8 {
+	var device string
+	var trackNumber int
+	var save bool
+
+	flags := flag.NewFlagSet("sona detect-offset", flag.ContinueOnError)
+	flags.StringVar(&device, "device", "", "CD drive to detect the offset of, defaults to the first CD drive found")
+	flags.IntVar(&trackNumber, "track", 2, "track of the key disc to compare, it can't be the first or last track")
+	flags.BoolVar(&save, "save", false, "store the detected offset in the drive offsets config")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	device, err := resolveCDDrive(device, logger)
+	if err != nil {
+		log.Println(err)
+		logger.CreateErrorLog(err.Error())
+		return 1
+	}
+
+	disc, err := ReadDiscInfo(device, logger)
+	if err != nil {
+		log.Println(err)
+		logger.CreateErrorLog(err.Error())
+		return 1
+	}
+
+	matches, err := detectDriveOffset(device, disc, trackNumber, logger)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to detect offset: %s", err)
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+		return 1
+	}
+
+	if len(matches) == 0 {
+		errorMessage := "No offset matched AccurateRip, try another key disc or track"
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+		return 1
+	}
+
+	offsets := rankOffsets(matches)
+	for _, offset := range offsets {
+		message := fmt.Sprintf("Offset %+d matched AccurateRip with confidence %d", offset, matches[offset])
+		log.Println(message)
+		logger.CreateLog(message)
+	}
+	bestOffset := offsets[0]
+
+	if !save {
+		return 0
+	}
+
+	info, err := getDriveInfo(device)
+	if err != nil {
+		log.Println(err)
+		logger.CreateErrorLog(err.Error())
+		return 1
+	}
+
+	key := driveOffsetKey(info)
+	if err := saveDriveOffset(key, bestOffset); err != nil {
+		errorMessage := fmt.Sprintf("Failed to save offset for \"%s\": %s", key, err)
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+		return 1
+	}
+
+	message := fmt.Sprintf("Saved read offset %+d for \"%s\"", bestOffset, key)
+	log.Println(message)
+	logger.CreateLog(message)
+
+	return 0
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRankOffsetsBreaksTiesTowardsZero(t *testing.T) {
+	matches := map[int]int{667: 5, -6: 12, 6: 12, 102: 12, -30: 3}
+
+	// Map order changes between runs so rank a few times
+	for range 20 {
+		got := rankOffsets(matches)
+		want := []int{-6, 6, 102, 667, -30}
+		if !slices.Equal(got, want) {
+			t.Fatalf("rankOffsets = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestFindMatchingOffsetsMatchesV2(t *testing.T) {
+	// Large samples so the products overflow 32 bits and the v1 and v2 checksums differ
+	samples := make([]uint32, 64)
+	for i := range samples {
+		samples[i] = 0xF0000000 + uint32(i)*7919
+	}
+
+	trackStart, trackLength := 20, 24
+	CRCv1, CRCv2 := computeAccurateRipCRCs(samples[trackStart+3:trackStart+3+trackLength], false, false)
+	if CRCv1 == CRCv2 {
+		t.Fatal("The v1 and v2 checksums are the same, the test can't tell them apart")
+	}
+
+	matches := findMatchingOffsets(samples, trackStart, trackLength, 10, map[uint32]int{CRCv2: 4})
+	if len(matches) != 1 || matches[3] != 4 {
+		t.Errorf("findMatchingOffsets = %v, want offset 3 with confidence 4", matches)
+	}
+
+	matches = findMatchingOffsets(samples, trackStart, trackLength, 10, map[uint32]int{CRCv1: 2, CRCv2: 4})
+	if len(matches) != 1 || matches[3] != 6 {
+		t.Errorf("findMatchingOffsets = %v, want offset 3 with confidence 6", matches)
+	}
+}
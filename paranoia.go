@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// Callback codes cdparanoia prints in its -e machine readable output
+const (
+	PARANOIA_CB_FINISHED      = -2
+	PARANOIA_CB_WROTE         = -1
+	PARANOIA_CB_READ          = 0
+	PARANOIA_CB_VERIFY        = 1
+	PARANOIA_CB_FIXUP_EDGE    = 2
+	PARANOIA_CB_FIXUP_ATOM    = 3
+	PARANOIA_CB_SCRATCH       = 4
+	PARANOIA_CB_REPAIR        = 5
+	PARANOIA_CB_SKIP          = 6
+	PARANOIA_CB_DRIFT         = 7
+	PARANOIA_CB_BACKOFF       = 8
+	PARANOIA_CB_OVERLAP       = 9
+	PARANOIA_CB_FIXUP_DROPPED = 10
+	PARANOIA_CB_FIXUP_DUPED   = 11
+	PARANOIA_CB_READERR       = 12
+	PARANOIA_CB_CACHEERR      = 13
+)
+
+// cdparanoia reports positions in 16 bit words, a 2352 byte sector holds 1176 of them
+const PARANOIA_WORDS_PER_SECTOR = 1176
+
+// A line of cdparanoia's -e output
+type ParanoiaEvent struct {
+	// Track being ripped when using batch mode, 0 when it isn't known
+	TrackNumber int
+	Code        int
+	Name        string
+	// Position on the disc in 16 bit words
+	Position int64
+}
+
+// Sector on the disc the event happened at, without the lead-in
+func (e ParanoiaEvent) Sector() int {
+	return int(e.Position / PARANOIA_WORDS_PER_SECTOR)
+}
+
+// Whether cdparanoia couldn't read the position correctly so the audio there may be damaged
+func (e ParanoiaEvent) IsSuspicious() bool {
+	return e.Code == PARANOIA_CB_SKIP || e.Code == PARANOIA_CB_READERR
+}
+
+var (
+	paranoiaEventPattern  = regexp.MustCompile(`^##: (-?\d+) \[([^\]]*)\] @ (-?\d+)`)
+	paranoiaOutputPattern = regexp.MustCompile(`outputting to track(\d+)\.`)
+)
+
+// Reads cdparanoia's stderr calling onEvent for each -e line. Every other line is copied to passthrough.
+func scanParanoiaOutput(reader io.Reader, passthrough io.Writer, onEvent func(ParanoiaEvent)) error {
+	trackNumber := 0
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		match := paranoiaEventPattern.FindStringSubmatch(line)
+		if match == nil {
+			if outputMatch := paranoiaOutputPattern.FindStringSubmatch(line); outputMatch != nil {
+				trackNumber, _ = strconv.Atoi(outputMatch[1])
+			}
+
+			if passthrough != nil {
+				fmt.Fprintln(passthrough, line)
+			}
+			continue
+		}
+
+		code, _ := strconv.Atoi(match[1])
+		position, _ := strconv.ParseInt(match[3], 10, 64)
+		onEvent(ParanoiaEvent{
+			TrackNumber: trackNumber,
+			Code:        code,
+			Name:        match[2],
+			Position:    position,
+		})
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mikogd/maokai"
+)
+
+// Folder discs ripped while their metadata couldn't be looked up wait in. Set with PENDING_DIR, defaults to
+// .sona-pending in PATH_TO_DEST_MUSIC so the rips can be moved into the music folder without copying them.
+var PENDING_DIR = ""
+
+const PENDING_MANIFEST_FILE_NAME = "manifest.json"
+
+// A disc ripped while its metadata couldn't be looked up, waiting in its own folder in the pending area to be tagged
+type PendingRip struct {
+	Disc  DiscInfo
+	Drive DriveInfo
+	// Disc number given when ripping, 0 to work it out from the release
+	DiscNumberOverride uint8
+	Image              bool
+	IndexScan          TrackIndexScan
+	Report             RipReport
+	// Report.AccurateRip.LookupError as text, errors don't survive being written as JSON
+	AccurateRipLookupError string
+	RippedAt               time.Time
+}
+
+func pendingDirectory() (string, error) {
+	if PENDING_DIR != "" {
+		return PENDING_DIR, nil
+	}
+
+	pathToMusicFolder := os.Getenv("PATH_TO_DEST_MUSIC")
+	if pathToMusicFolder == "" {
+		return "", errors.New("Neither PENDING_DIR nor PATH_TO_DEST_MUSIC are set")
+	}
+
+	return filepath.Join(pathToMusicFolder, ".sona-pending"), nil
+}
+
+// Creates the folder a disc is ripped into, named after its disc ID and the time so the same disc can be pending twice
+func newPendingRipDirectory(disc DiscInfo) (string, error) {
+	pendingPath, err := pendingDirectory()
+	if err != nil {
+		return "", err
+	}
+
+	directory := filepath.Join(pendingPath, fmt.Sprintf("%s-%s", disc.ID, time.Now().Format("20060102-150405")))
+	if err := os.MkdirAll(directory, 0777); err != nil {
+		errorMessage := fmt.Sprintf("Failed to create directory %s: %s", directory, err)
+		return "", errors.New(errorMessage)
+	}
+
+	return directory, nil
+}
+
+func (pending PendingRip) Save(directory string) error {
+	// Progress only matters while ripping
+	pending.Report.Settings.Progress = nil
+	if pending.Report.AccurateRip.LookupError != nil {
+		pending.AccurateRipLookupError = pending.Report.AccurateRip.LookupError.Error()
+		pending.Report.AccurateRip.LookupError = nil
+	}
+
+	data, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(directory, PENDING_MANIFEST_FILE_NAME), data, 0644)
+}
+
+func loadPendingRip(directory string) (PendingRip, error) {
+	pending := PendingRip{}
+	data, err := os.ReadFile(filepath.Join(directory, PENDING_MANIFEST_FILE_NAME))
+	if err != nil {
+		return pending, err
+	}
+
+	if err := json.Unmarshal(data, &pending); err != nil {
+		errorMessage := fmt.Sprintf("Failed to parse %s: %s", filepath.Join(directory, PENDING_MANIFEST_FILE_NAME), err)
+		return pending, errors.New(errorMessage)
+	}
+
+	if pending.AccurateRipLookupError != "" {
+		pending.Report.AccurateRip.LookupError = errors.New(pending.AccurateRipLookupError)
+	}
+
+	return pending, nil
+}
+
+// Folders in the pending area holding a manifest, oldest rip first
+func listPendingRips() ([]string, error) {
+	pendingPath, err := pendingDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(pendingPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	directories := []string{}
+	for _, entry := range entries {
+		directory := filepath.Join(pendingPath, entry.Name())
+		// A folder without a manifest is a rip that didn't finish
+		if _, err := os.Stat(filepath.Join(directory, PENDING_MANIFEST_FILE_NAME)); entry.IsDir() && err == nil {
+			directories = append(directories, directory)
+		}
+	}
+
+	loaded := map[string]time.Time{}
+	for _, directory := range directories {
+		if pending, err := loadPendingRip(directory); err == nil {
+			loaded[directory] = pending.RippedAt
+		}
+	}
+	sort.SliceStable(directories, func(i, j int) bool {
+		return loaded[directories[i]].Before(loaded[directories[j]])
+	})
+
+	return directories, nil
+}
+
+// Rips the disc into a new folder in the pending area, saving what is needed to tag it later with sona tag-pending
+func ripPending(
+	options RipOptions,
+	device string,
+	driveInfo DriveInfo,
+	readOffset int,
+	disc DiscInfo,
+	discNumberOverride uint8,
+	logger *maokai.FileLogger,
+) uint8 {
+	directory, err := newPendingRipDirectory(disc)
+	if err != nil {
+		log.Println(err)
+		logger.CreateErrorLog(err.Error())
+		return 1
+	}
+
+	message := fmt.Sprintf("Metadata for disc %s couldn't be looked up, ripping it into %s to tag later with "+
+		"sona tag-pending", disc.ID, directory)
+	log.Println(message)
+	logger.CreateLog(message)
+
+	startingWorkingDirectory, err := os.Getwd()
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to get the current working directory: %s", err)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+		return 1
+	}
+
+	if err = os.Chdir(directory); err != nil {
+		errorMessage := fmt.Sprintf("Failed to do change current working directory to %s", directory)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+		return 1
+	}
+
+	defer changeDirectory(startingWorkingDirectory)
+
+	ripReport, indexScan, err := ripDisc(options, device, readOffset, disc, directory, logger)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to rip CD: %s", err)
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+		if err := os.RemoveAll(directory); err != nil {
+			logger.CreateErrorLogf("Failed to remove %s: %s", directory, err)
+		}
+		return 1
+	}
+
+	// The wavs are kept until tag-pending has checked the rip against AccurateRip again, tagRip then removes those
+	// that pass
+	pending := PendingRip{
+		Disc:               disc,
+		Drive:              driveInfo,
+		DiscNumberOverride: discNumberOverride,
+		Image:              options.Image,
+		IndexScan:          indexScan,
+		Report:             ripReport,
+		RippedAt:           time.Now(),
+	}
+	if err := pending.Save(directory); err != nil {
+		errorMessage := fmt.Sprintf("Failed to save the manifest of %s: %s", directory, err)
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+		return 1
+	}
+
+	message = fmt.Sprintf("Disc %s ripped, run sona tag-pending once metadata can be looked up", disc.ID)
+	log.Println(message)
+	logger.CreateLog(message)
+
+	return 0
+}
+
+// Checks the rip against AccurateRip again when the database couldn't be reached while ripping
+func reverifyAccurateRip(report *RipReport, toc DiscTOC, logger maokai.Logger) {
+	response, err := fetchAccurateRip(report.AccurateRip.DiscID, logger)
+	report.AccurateRip.LookupError = err
+	report.AccurateRip.InDatabase = len(response.Pressings) > 0
+	if err != nil {
+		return
+	}
+
+	for i := range report.Tracks {
+		track := &report.Tracks[i].AccurateRip
+		track.Confidence, track.Version = 0, 0
+		compareAccurateRip(track, track.TrackNumber-toc.FirstTrack, response)
+	}
+
+	report.AccurateRip.Tracks = []AccurateRipTrackResult{}
+	for _, track := range report.Tracks {
+		report.AccurateRip.Tracks = append(report.AccurateRip.Tracks, track.AccurateRip)
+	}
+
+	reportAccurateRip(report.AccurateRip, logger)
+}
+
+// Moves everything tagRip left in the pending folder, the tagged tracks or image, cover, cue sheet, rip log and kept
+// wavs, into the album's folder. The manifest stays behind. Nothing is moved when a file is already in the album's
+// folder, e.g. from an earlier rip of the disc, so it isn't overwritten.
+func moveTaggedFiles(directory string, pathToAlbum string, logger maokai.Logger) error {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return err
+	}
+
+	fileNames := []string{}
+	existing := []string{}
+	for _, entry := range entries {
+		if entry.Name() == PENDING_MANIFEST_FILE_NAME {
+			continue
+		}
+
+		fileNames = append(fileNames, entry.Name())
+		if _, err := os.Lstat(filepath.Join(pathToAlbum, entry.Name())); err == nil {
+			existing = append(existing, entry.Name())
+		}
+	}
+
+	if len(existing) > 0 {
+		errorMessage := fmt.Sprintf("%s already has %s, the tagged files are left in %s", pathToAlbum,
+			strings.Join(existing, ", "), directory)
+		return errors.New(errorMessage)
+	}
+
+	for _, fileName := range fileNames {
+		src := filepath.Join(directory, fileName)
+		dest := filepath.Join(pathToAlbum, fileName)
+		logger.CreateLogf("Moving %s to %s", src, dest)
+		if err := os.Rename(src, dest); err != nil {
+			errorMessage := fmt.Sprintf("Failed to move %s to %s: %s, the tagged files are split between %s and %s",
+				src, dest, err, directory, pathToAlbum)
+			return errors.New(errorMessage)
+		}
+	}
+
+	return nil
+}
+
+// Looks the pending rip in directory up, then tags it and stores it in PATH_TO_DEST_MUSIC. The tracks are tagged in
+// the pending folder and only moved once tagging succeeded, a failure leaves the rip pending as it was. Returns
+// ErrLookupFailed when metadata still can't be looked up.
+func tagPendingRip(
+	options RipOptions,
+	providers []MetadataProvider,
+	client *MusicBrainzClient,
+	directory string,
+	logger *maokai.FileLogger,
+) error {
+	pending, err := loadPendingRip(directory)
+	if err != nil {
+		return err
+	}
+
+	disc := pending.Disc
+	// The disc has long left the drive
+	disc.Device = ""
+
+	message := fmt.Sprintf("Tagging disc %s ripped %s", disc.ID, pending.RippedAt.Format(time.DateTime))
+	log.Println(message)
+	logger.CreateLog(message)
+
+	unknownDiscNumber := uint8(max(pending.DiscNumberOverride, 1))
+	release, err := chooseRelease(options, providers, client, disc, unknownDiscNumber, logger)
+	if err != nil {
+		return err
+	}
+
+	discNumber := resolveDiscNumber(release, disc, pending.DiscNumberOverride, logger)
+	songs, err := GetFlacTags(release, discNumber, logger)
+	if err != nil {
+		return err
+	}
+	applyDiscCodes(songs, disc, release, discNumber, logger)
+
+	ripReport := pending.Report
+	if ripReport.AccurateRip.LookupError != nil {
+		reverifyAccurateRip(&ripReport, disc.TOC, logger)
+	}
+
+	// Made before tagging so a missing PATH_TO_DEST_MUSIC doesn't leave tagged files in the pending folder
+	pathToAlbum, err := createAlbumDirectory(release, logger)
+	if err != nil {
+		return err
+	}
+
+	startingWorkingDirectory, err := os.Getwd()
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to get the current working directory: %s", err)
+		return errors.New(errorMessage)
+	}
+
+	if err = os.Chdir(directory); err != nil {
+		errorMessage := fmt.Sprintf("Failed to do change current working directory to %s", directory)
+		return errors.New(errorMessage)
+	}
+
+	code := tagRip(pending.Image, release, disc, discNumber, songs, pending.Drive, ripReport, pending.IndexScan,
+		directory, logger)
+	changeDirectory(startingWorkingDirectory)
+	if code != 0 {
+		errorMessage := fmt.Sprintf("Failed to tag the tracks in %s", directory)
+		return errors.New(errorMessage)
+	}
+
+	if err := moveTaggedFiles(directory, pathToAlbum, logger); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(directory); err != nil {
+		logger.CreateErrorLogf("Failed to remove %s: %s", directory, err)
+	}
+
+	return nil
+}
+
+func runTagPendingCommand(args []string, logger *maokai.FileLogger) uint8 {
+	options := RipOptions{}
+
+	flags := flag.NewFlagSet("sona tag-pending", flag.ContinueOnError)
+	flags.BoolVar(&options.Refresh, "refresh", false, "look the discs up on MusicBrainz even when an earlier lookup is cached")
+	pick := flags.String("pick", "", "how to pick between releases: ask, best or first, defaults to ask when run from a terminal and best otherwise")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: sona tag-pending [options]")
+		fmt.Fprintln(flags.Output(), "\nTags the discs ripped while metadata couldn't be looked up and moves them into PATH_TO_DEST_MUSIC.")
+		fmt.Fprintln(flags.Output(), "\nOptions:")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+
+	options.Pick, err = parseReleasePickPolicy(*pick)
+	if err != nil {
+		fmt.Fprintln(flags.Output(), err)
+		return 2
+	}
+
+	directories, err := listPendingRips()
+	if err != nil {
+		log.Println(err)
+		logger.CreateErrorLog(err.Error())
+		return 1
+	}
+
+	if len(directories) == 0 {
+		log.Println("No discs are waiting to be tagged")
+		return 0
+	}
+
+	musicBrainz := NewMusicBrainzClient(API_URL, logger)
+	metadataCache, err := NewMetadataCache(options.Refresh, logger)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to open the metadata cache: %v", err)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+	}
+	providers := newMetadataProviders(musicBrainz, metadataCache, options, logger)
+
+	var code uint8 = 0
+	for _, directory := range directories {
+		err := tagPendingRip(options, providers, musicBrainz, directory, logger)
+		if errors.Is(err, ErrLookupFailed) {
+			errorMessage := "Metadata still can't be looked up, leaving the remaining discs pending"
+			log.Println(errorMessage)
+			logger.CreateErrorLog(errorMessage)
+			return 1
+		}
+		if err != nil {
+			// Left pending so it can be tried again, e.g. after the disc has been added to MusicBrainz
+			errorMessage := fmt.Sprintf("Failed to tag %s, leaving it pending: %v", directory, err)
+			log.Println(errorMessage)
+			logger.CreateErrorLog(errorMessage)
+			code = 1
+		}
+	}
+
+	return code
+}
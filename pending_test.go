@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTagRipLeavesTracksWhenTaggingFails(t *testing.T) {
+	directory := t.TempDir()
+	t.Chdir(directory)
+
+	// Not a flac file, so tagging it fails
+	if err := os.WriteFile("track01.flac", []byte("not flac"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("track01.cdda.wav", []byte("wav"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	release := ReleaseInfo{Title: "Album", Media: []MediumInfo{{Position: 1, Format: "CD"}}}
+	songs := []FlacTags{{Title: "First", TrackNumber: 1, DiscNumber: 1}}
+	disc := DiscInfo{ID: "disc", TOC: DiscTOC{FirstTrack: 1, LastTrack: 1, Offsets: []int{150}, LeadOut: 20000}}
+
+	code := tagRip(false, release, disc, 1, songs, DriveInfo{}, RipReport{}, TrackIndexScan{}, directory, discardLogger{})
+	if code != 1 {
+		t.Errorf("tagRip returned %d, want 1", code)
+	}
+
+	for _, fileName := range []string{"track01.flac", "track01.cdda.wav"} {
+		if _, err := os.Stat(filepath.Join(directory, fileName)); err != nil {
+			t.Errorf("%s is gone after tagging failed: %v", fileName, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(directory, "01. First-no-tags.flac")); err == nil {
+		t.Error("01. First-no-tags.flac was left behind, want it renamed back to track01.flac")
+	}
+}
+
+func TestMoveTaggedFilesKeepsManifest(t *testing.T) {
+	directory, pathToAlbum := t.TempDir(), t.TempDir()
+	for _, fileName := range []string{PENDING_MANIFEST_FILE_NAME, "01. First.flac", "Album.cue", "track02.cdda.wav"} {
+		if err := os.WriteFile(filepath.Join(directory, fileName), []byte(fileName), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := moveTaggedFiles(directory, pathToAlbum, discardLogger{}); err != nil {
+		t.Fatalf("moveTaggedFiles: %v", err)
+	}
+
+	for _, fileName := range []string{"01. First.flac", "Album.cue", "track02.cdda.wav"} {
+		if _, err := os.Stat(filepath.Join(pathToAlbum, fileName)); err != nil {
+			t.Errorf("%s wasn't moved: %v", fileName, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(directory, PENDING_MANIFEST_FILE_NAME)); err != nil {
+		t.Errorf("The manifest was moved: %v", err)
+	}
+}
+
+func TestMoveTaggedFilesRefusesToOverwrite(t *testing.T) {
+	directory, pathToAlbum := t.TempDir(), t.TempDir()
+	for _, fileName := range []string{"01. First.flac", "02. Second.flac"} {
+		if err := os.WriteFile(filepath.Join(directory, fileName), []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(pathToAlbum, "02. Second.flac"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := moveTaggedFiles(directory, pathToAlbum, discardLogger{})
+	if err == nil || !strings.Contains(err.Error(), "02. Second.flac") {
+		t.Fatalf("moveTaggedFiles returned %v, want an error naming 02. Second.flac", err)
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(pathToAlbum, "02. Second.flac")); string(content) != "old" {
+		t.Error("02. Second.flac in the album's folder was overwritten")
+	}
+	if _, err := os.Stat(filepath.Join(directory, "01. First.flac")); err != nil {
+		t.Errorf("01. First.flac was moved although another file couldn't be: %v", err)
+	}
+}
+
+func TestPendingRipManifestRoundTrip(t *testing.T) {
+	directory := t.TempDir()
+	pending := PendingRip{
+		Disc: DiscInfo{
+			ID:  "Wn8eRBtfLDfM0qjYPdxrz.Zjs_U-",
+			TOC: DiscTOC{FirstTrack: 1, LastTrack: 1, Offsets: []int{150}, LeadOut: 20000},
+		},
+		DiscNumberOverride: 2,
+		Image:              true,
+		Report: RipReport{
+			Settings:    RipSettings{Device: "/dev/sr0", ReadOffset: 6, Progress: &ProgressReporter{}},
+			Tracks:      []TrackReport{{TrackNumber: 1, CopyCRC: 0x1234abcd}},
+			AccurateRip: AccurateRipResult{LookupError: ErrLookupFailed},
+		},
+		RippedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	if err := pending.Save(directory); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if pending.Report.Settings.Progress == nil || pending.Report.AccurateRip.LookupError == nil {
+		t.Error("Save changed the rip it was given")
+	}
+
+	loaded, err := loadPendingRip(directory)
+	if err != nil {
+		t.Fatalf("loadPendingRip: %v", err)
+	}
+	if loaded.Disc.ID != pending.Disc.ID || loaded.DiscNumberOverride != 2 || !loaded.Image ||
+		!loaded.RippedAt.Equal(pending.RippedAt) {
+		t.Errorf("loadPendingRip returned %+v", loaded)
+	}
+	if loaded.Report.Settings.ReadOffset != 6 || loaded.Report.Settings.Progress != nil {
+		t.Errorf("Loaded settings %+v, want read offset 6 without progress", loaded.Report.Settings)
+	}
+	if len(loaded.Report.Tracks) != 1 || loaded.Report.Tracks[0].CopyCRC != 0x1234abcd {
+		t.Errorf("Loaded tracks %+v", loaded.Report.Tracks)
+	}
+	lookupError := loaded.Report.AccurateRip.LookupError
+	if lookupError == nil || lookupError.Error() != ErrLookupFailed.Error() {
+		t.Errorf("Loaded lookup error %v, want %v", lookupError, ErrLookupFailed)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mikogd/maokai"
+)
+
+type ProgressEventType int
+
+const (
+	TrackStarted ProgressEventType = iota
+	TrackProgress
+	// cdparanoia had to correct or skip part of the track
+	TrackCorrection
+	TrackDone
+)
+
+func (t ProgressEventType) String() string {
+	switch t {
+	case TrackStarted:
+		return "started"
+	case TrackProgress:
+		return "progress"
+	case TrackCorrection:
+		return "correction"
+	default:
+		return "done"
+	}
+}
+
+// Which child process a progress event came from
+type ProgressStage string
+
+const (
+	RipStage    ProgressStage = "rip"
+	EncodeStage ProgressStage = "encode"
+)
+
+type ProgressEvent struct {
+	Type        ProgressEventType
+	Stage       ProgressStage
+	TrackNumber int
+	// How much of the track is done from 0 to 1
+	Fraction float64
+	// Multiple of real time the track is being processed at
+	Speed float64
+	// Errors cdparanoia corrected and positions it had to skip so far in the track
+	Corrections int
+	Skips       int
+	Time        time.Time
+}
+
+// Receives progress events while a disc is ripped and converted
+type ProgressListener interface {
+	OnProgress(event ProgressEvent)
+}
+
+type ProgressListenerFunc func(event ProgressEvent)
+
+func (f ProgressListenerFunc) OnProgress(event ProgressEvent) {
+	f(event)
+}
+
+// Passes progress events on to every listener added. A nil reporter drops every event.
+type ProgressReporter struct {
+	mutex     sync.Mutex
+	listeners []ProgressListener
+}
+
+func (r *ProgressReporter) AddListener(listener ProgressListener) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.listeners = append(r.listeners, listener)
+}
+
+func (r *ProgressReporter) Report(event ProgressEvent) {
+	if r == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, listener := range r.listeners {
+		listener.OnProgress(event)
+	}
+}
+
+// Turns cdparanoia's -e events for a track into progress events
+type paranoiaProgress struct {
+	reporter *ProgressReporter
+	toc      DiscTOC
+	// cdparanoia is writing the whole disc to one file so it doesn't say which track it is on
+	wholeDisc   bool
+	trackNumber int
+	startedAt   time.Time
+	corrections int
+	skips       int
+}
+
+func (p *paranoiaProgress) reset() {
+	p.trackNumber = 0
+}
+
+func (p *paranoiaProgress) onEvent(event ParanoiaEvent) {
+	if event.TrackNumber < p.toc.FirstTrack || event.TrackNumber > p.toc.LastTrack {
+		return
+	}
+
+	if event.TrackNumber != p.trackNumber {
+		p.trackNumber = event.TrackNumber
+		p.startedAt = time.Now()
+		p.corrections = 0
+		p.skips = 0
+		p.reporter.Report(ProgressEvent{Type: TrackStarted, Stage: RipStage, TrackNumber: p.trackNumber})
+	}
+
+	progressEvent := ProgressEvent{
+		Stage:       RipStage,
+		TrackNumber: p.trackNumber,
+		Corrections: p.corrections,
+		Skips:       p.skips,
+	}
+
+	switch event.Code {
+	case PARANOIA_CB_WROTE:
+		trackStart := p.toc.TrackOffset(p.trackNumber) - LEAD_IN_SECTORS
+		sectorsDone := event.Sector() - trackStart
+		progressEvent.Type = TrackProgress
+		progressEvent.Fraction = min(max(float64(sectorsDone)/float64(p.toc.TrackSectors(p.trackNumber)), 0), 1)
+		if elapsed := time.Since(p.startedAt).Seconds(); elapsed > 0 {
+			progressEvent.Speed = float64(sectorsDone) / SECTORS_PER_SECOND / elapsed
+		}
+	case PARANOIA_CB_SKIP, PARANOIA_CB_READERR:
+		p.skips++
+		progressEvent.Type = TrackCorrection
+		progressEvent.Skips = p.skips
+	case PARANOIA_CB_FIXUP_EDGE, PARANOIA_CB_FIXUP_ATOM, PARANOIA_CB_SCRATCH, PARANOIA_CB_REPAIR,
+		PARANOIA_CB_FIXUP_DROPPED, PARANOIA_CB_FIXUP_DUPED:
+		p.corrections++
+		progressEvent.Type = TrackCorrection
+		progressEvent.Corrections = p.corrections
+	case PARANOIA_CB_FINISHED:
+		progressEvent.Type = TrackDone
+		progressEvent.Fraction = 1
+	default:
+		return
+	}
+
+	p.reporter.Report(progressEvent)
+}
+
+// Reads ffmpeg's -progress output, a block of key=value lines ending in progress=continue or progress=end
+func scanFFmpegProgress(reader io.Reader, trackNumber int, duration time.Duration, reporter *ProgressReporter) error {
+	reporter.Report(ProgressEvent{Type: TrackStarted, Stage: EncodeStage, TrackNumber: trackNumber})
+
+	event := ProgressEvent{Type: TrackProgress, Stage: EncodeStage, TrackNumber: trackNumber}
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "out_time_us":
+			microseconds, err := strconv.ParseInt(value, 10, 64)
+			if err == nil && duration > 0 {
+				event.Fraction = min(max(float64(microseconds)/float64(duration.Microseconds()), 0), 1)
+			}
+		case "speed":
+			event.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64)
+		case "progress":
+			if value == "end" {
+				event.Type = TrackDone
+				event.Fraction = 1
+			}
+			event.Time = time.Time{}
+			reporter.Report(event)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Draws a bar for the current track and the whole disc on the terminal with an estimate of the time left
+type TerminalProgress struct {
+	Output       io.Writer
+	TOC          DiscTOC
+	stage        ProgressStage
+	stageStarted time.Time
+	lastDrawn    time.Time
+}
+
+func progressBar(fraction float64, width int) string {
+	filled := int(fraction * float64(width))
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
+
+// Fraction of the disc done, weighting each track by its length
+func (t *TerminalProgress) discFraction(event ProgressEvent) float64 {
+	totalSectors := t.TOC.LeadOut - t.TOC.Offsets[0]
+	if totalSectors <= 0 || event.TrackNumber < t.TOC.FirstTrack || event.TrackNumber > t.TOC.LastTrack {
+		return 0
+	}
+
+	sectorsDone := t.TOC.TrackOffset(event.TrackNumber) - t.TOC.Offsets[0]
+	sectorsDone += int(event.Fraction * float64(t.TOC.TrackSectors(event.TrackNumber)))
+
+	return float64(sectorsDone) / float64(totalSectors)
+}
+
+func (t *TerminalProgress) OnProgress(event ProgressEvent) {
+	if event.Stage != t.stage {
+		t.stage = event.Stage
+		t.stageStarted = event.Time
+	}
+
+	// Redrawing on every cdparanoia read floods the terminal
+	if event.Type == TrackProgress && event.Time.Sub(t.lastDrawn) < 100*time.Millisecond {
+		return
+	}
+	t.lastDrawn = event.Time
+
+	verb := "Ripping"
+	if event.Stage == EncodeStage {
+		verb = "Encoding"
+	}
+
+	discFraction := t.discFraction(event)
+	ETA := "--:--"
+	if elapsed := event.Time.Sub(t.stageStarted); discFraction > 0 && elapsed > 0 {
+		remaining := time.Duration(float64(elapsed)/discFraction) - elapsed
+		ETA = fmt.Sprintf("%d:%02d", int(remaining.Minutes()), int(remaining.Seconds())%60)
+	}
+
+	line := fmt.Sprintf("%s track %02d %s %3.0f%% | disc %s %3.0f%% | %4.1fx | ETA %s",
+		verb, event.TrackNumber, progressBar(event.Fraction, 20), event.Fraction*100,
+		progressBar(discFraction, 20), discFraction*100, event.Speed, ETA)
+	if event.Corrections > 0 || event.Skips > 0 {
+		line += fmt.Sprintf(" | %d corrected, %d skipped", event.Corrections, event.Skips)
+	}
+
+	fmt.Fprintf(t.Output, "\r\033[K%s", line)
+	if event.Type == TrackDone {
+		fmt.Fprintln(t.Output)
+	}
+}
+
+// Writes the start and end of every track and each correction to the log
+type LoggerProgress struct {
+	Logger maokai.Logger
+}
+
+func (l LoggerProgress) OnProgress(event ProgressEvent) {
+	switch event.Type {
+	case TrackStarted:
+		l.Logger.CreateLogf("%s of track %02d started", event.Stage, event.TrackNumber)
+	case TrackCorrection:
+		l.Logger.CreateLogf("%s of track %02d: %d corrections, %d skips", event.Stage, event.TrackNumber,
+			event.Corrections, event.Skips)
+	case TrackDone:
+		l.Logger.CreateLogf("%s of track %02d done at %.1fx", event.Stage, event.TrackNumber, event.Speed)
+	}
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Creates a reporter logging progress and drawing it on stderr when stderr is a terminal
+func newProgressReporter(toc DiscTOC, logger maokai.Logger) *ProgressReporter {
+	reporter := &ProgressReporter{}
+	reporter.AddListener(LoggerProgress{Logger: logger})
+
+	if isTerminal(os.Stderr) {
+		reporter.AddListener(&TerminalProgress{Output: os.Stderr, TOC: toc})
+	}
+
+	return reporter
+}
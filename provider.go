@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mikogd/maokai"
+)
+
+// Sources a release can come from
+const (
+	SOURCE_MUSICBRAINZ = "MusicBrainz"
+	SOURCE_GNUDB       = "GnuDB"
+	SOURCE_DISCOGS     = "Discogs"
+	SOURCE_CDTEXT      = "CD-Text"
+	SOURCE_TAGS_FILE   = "Tags file"
+)
+
+// Longest a single request to GnuDB or Discogs may take
+const METADATA_PROVIDER_TIMEOUT = 30 * time.Second
+
+// Returned when no provider could be asked about the disc, usually because the network is down
+var ErrLookupFailed = errors.New("No metadata provider could be reached")
+
+// Providers tried in order, set with METADATA_PROVIDERS e.g. musicbrainz,gnudb,discogs
+var METADATA_PROVIDERS = []string{"musicbrainz"}
+
+// Looks discs up in a metadata database
+type MetadataProvider interface {
+	// Source the provider's candidates are marked with
+	Name() string
+	// Returns the releases the disc could be, scored against it. Returns ErrNoRelease when the provider doesn't know
+	// the disc.
+	Lookup(ctx context.Context, disc DiscInfo) ([]ReleaseCandidate, error)
+}
+
+// Looks discs up by their disc ID on MusicBrainz, through the metadata cache
+type MusicBrainzProvider struct {
+	Client *MusicBrainzClient
+	// May be nil
+	Cache  *MetadataCache
+	Logger *maokai.FileLogger
+	// Open the submission page in a browser when MusicBrainz doesn't know the disc
+	OpenSubmissionURL bool
+	// How long to wait for the disc to be submitted when MusicBrainz doesn't know it, 0 to give up straight away
+	WaitForSubmit time.Duration
+}
+
+func (provider MusicBrainzProvider) Name() string {
+	return SOURCE_MUSICBRAINZ
+}
+
+func (provider MusicBrainzProvider) Lookup(ctx context.Context, disc DiscInfo) ([]ReleaseCandidate, error) {
+	metadata, err := GetMetaDataForCD(provider.Client, provider.Cache, disc, provider.Logger)
+	if err != nil {
+		var notFound *MusicBrainzNotFoundError
+		if !errors.As(err, &notFound) {
+			return nil, err
+		}
+
+		reportUnknownDisc(provider, disc)
+		if provider.WaitForSubmit <= 0 {
+			return nil, ErrNoRelease
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, provider.WaitForSubmit)
+		defer cancel()
+
+		metadata, err = waitForSubmission(waitCtx, provider, disc)
+		if errors.Is(err, context.DeadlineExceeded) {
+			// The later providers may still know the disc
+			message := fmt.Sprintf("Disc %s wasn't attached on MusicBrainz within %s", disc.ID, provider.WaitForSubmit)
+			log.Println(message)
+			provider.Logger.CreateLog(message)
+			return nil, ErrNoRelease
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return RankReleases(metadata, disc, provider.Logger)
+}
+
+func loadMetadataProviders() error {
+	providersString := os.Getenv("METADATA_PROVIDERS")
+	if providersString == "" {
+		return nil
+	}
+
+	providers := splitPreferenceList(strings.ToLower(providersString))
+	for _, provider := range providers {
+		switch provider {
+		case "musicbrainz", "gnudb", "discogs":
+		default:
+			errorMessage := fmt.Sprintf("Unknown metadata provider \"%s\", use musicbrainz, gnudb or discogs", provider)
+			return errors.New(errorMessage)
+		}
+	}
+	METADATA_PROVIDERS = providers
+
+	return nil
+}
+
+// Builds the providers in METADATA_PROVIDERS' order
+func newMetadataProviders(
+	client *MusicBrainzClient,
+	cache *MetadataCache,
+	options RipOptions,
+	logger *maokai.FileLogger,
+) []MetadataProvider {
+	providers := []MetadataProvider{}
+	for _, name := range METADATA_PROVIDERS {
+		switch name {
+		case "musicbrainz":
+			providers = append(providers, MusicBrainzProvider{
+				Client:            client,
+				Cache:             cache,
+				Logger:            logger,
+				OpenSubmissionURL: options.OpenSubmissionURL,
+				WaitForSubmit:     options.WaitForSubmit,
+			})
+		case "gnudb":
+			providers = append(providers, NewGnuDBProvider(logger))
+		case "discogs":
+			providers = append(providers, NewDiscogsProvider(logger))
+		}
+	}
+
+	return providers
+}
+
+// Asks the providers in order until one has a release matching the disc closely enough to be picked without asking,
+// returning every candidate found on the way ranked best first. Returns ErrLookupFailed when every provider failed
+// without saying whether it knows the disc.
+func lookupRelease(
+	ctx context.Context,
+	providers []MetadataProvider,
+	disc DiscInfo,
+	logger maokai.Logger,
+) ([]ReleaseCandidate, error) {
+	candidates := []ReleaseCandidate{}
+	failed := 0
+	for _, provider := range providers {
+		providerCandidates, err := provider.Lookup(ctx, disc)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Failed to get a %s release: %v", provider.Name(), err)
+			logger.CreateErrorLog(errorMessage)
+			log.Println(errorMessage)
+			if !errors.Is(err, ErrNoRelease) {
+				failed++
+			}
+			continue
+		}
+
+		for _, candidate := range providerCandidates {
+			// Order runs across providers so the first release of the first provider stays first
+			candidate.Order = len(candidates)
+			candidates = append(candidates, candidate)
+		}
+
+		if len(providerCandidates) > 0 && providerCandidates[0].Confident() {
+			break
+		}
+	}
+
+	if len(providers) > 0 && failed == len(providers) {
+		return candidates, ErrLookupFailed
+	}
+
+	sortReleaseCandidates(candidates)
+
+	return candidates, nil
+}
+
+// GETs a URL from one of the providers returning the body of a 200 OK. A 404 is returned as ErrNoRelease.
+func providerGet(ctx context.Context, requestURL string, headers map[string]string, logger maokai.Logger) ([]byte, error) {
+	requestCtx, cancel := context.WithTimeout(ctx, METADATA_PROVIDER_TIMEOUT)
+	defer cancel()
+
+	logger.CreateLogf("Requesting %s", requestURL)
+	req, err := http.NewRequestWithContext(requestCtx, "GET", requestURL, nil)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error creating request: %s", err)
+		return nil, errors.New(errorMessage)
+	}
+	req.Header.Set("User-Agent", USER_AGENT)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error making request: %s", err)
+		return nil, errors.New(errorMessage)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNoRelease
+	}
+	if resp.StatusCode != http.StatusOK {
+		errorMessage := fmt.Sprintf("Request %s failed: %s", requestURL, resp.Status)
+		return nil, errors.New(errorMessage)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error reading body: %s", err)
+		return nil, errors.New(errorMessage)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import "strings"
+
+// Album artist of releases that credit no one, used for the album folder
+const UNKNOWN_ARTIST = "Unknown Artist"
+
+// An artist credited on a release or track
+type CreditedArtist struct {
+	Name string
+	// Joins the artist to the next one e.g. " feat. "
+	JoinPhrase string
+	// Only known for MusicBrainz releases
+	ID   string
+	Type string
+}
+
+// A track of a release's medium
+type TrackInfo struct {
+	// Position of the track on the medium, Number is what the release prints which may not be a number e.g. A1
+	Position uint8
+	Number   string
+	Title    string
+	// In milliseconds, 0 when the source doesn't know it
+	Length           uint32
+	Artists          []CreditedArtist
+	FirstReleaseDate string
+	Genres           []string
+	// ISRCs listed for the track's recording
+	ISRCs []string
+	// MusicBrainz IDs of the track and its recording
+	ID          string
+	RecordingID string
+}
+
+// A disc or other medium of a release
+type MediumInfo struct {
+	// 0 when the source doesn't know which of the release's media it is
+	Position uint8
+	Format   string
+	Title    string
+	// Disc IDs attached to the medium, only MusicBrainz has them
+	DiscIDs []string
+	Tracks  []TrackInfo
+}
+
+// A release the disc could be, the same whichever metadata provider it came from. Providers build it from their own
+// responses, fields a source doesn't have are left empty.
+type ReleaseInfo struct {
+	Title   string
+	Artists []CreditedArtist
+	// YYYY, YYYY-MM or YYYY-MM-DD
+	Date          string
+	Country       string
+	Barcode       string
+	Label         string
+	CatalogNumber string
+	// Official, Promotion, Bootleg or Pseudo-Release
+	Status string
+	// Release genres, falling back to the release group's for MusicBrainz releases without their own
+	Genres []string
+	// Primary type followed by the secondary types in lower case the way Picard writes them e.g. album, live
+	Types []string
+	Media []MediumInfo
+	// MusicBrainz IDs of the release and its release group, empty for other sources
+	ID             string
+	ReleaseGroupID string
+	// Where the release was found, one of the SOURCE_ constants, and its ID there when it has one
+	Source   string
+	SourceID string
+}
+
+// Joins the credited artists' names with their join phrases e.g. "Artist A feat. Artist B"
+func artistCreditString(artists []CreditedArtist) string {
+	var builder strings.Builder
+	for _, artist := range artists {
+		builder.WriteString(artist.Name)
+		builder.WriteString(artist.JoinPhrase)
+	}
+
+	return builder.String()
+}
+
+func artistNames(artists []CreditedArtist) []string {
+	names := make([]string, len(artists))
+	for i, artist := range artists {
+		names[i] = artist.Name
+	}
+
+	return names
+}
+
+// MBIDs of the credited artists
+func artistIDs(artists []CreditedArtist) []string {
+	IDs := []string{}
+	for _, artist := range artists {
+		if artist.ID != "" {
+			IDs = append(IDs, artist.ID)
+		}
+	}
+
+	return IDs
+}
+
+// Name of the release's first credited artist, UNKNOWN_ARTIST when it credits no one
+func releaseArtistName(release ReleaseInfo) string {
+	if len(release.Artists) == 0 || strings.TrimSpace(release.Artists[0].Name) == "" {
+		return UNKNOWN_ARTIST
+	}
+
+	return release.Artists[0].Name
+}
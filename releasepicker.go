@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mikogd/maokai"
+)
+
+// How a release is picked when the disc matches more than one
+type ReleasePickPolicy string
+
+const (
+	// List the ranked releases and ask which one to use
+	PickAsk ReleasePickPolicy = "ask"
+	// Use the top ranked release without asking, for unattended rips
+	PickBest ReleasePickPolicy = "best"
+	// Use the first CD release the first provider lists that matches the disc, how releases were picked before they
+	// were ranked
+	PickFirst ReleasePickPolicy = "first"
+)
+
+func parseReleasePickPolicy(value string) (ReleasePickPolicy, error) {
+	switch policy := ReleasePickPolicy(value); policy {
+	case "", PickAsk, PickBest, PickFirst:
+		return policy, nil
+	default:
+		errorMessage := fmt.Sprintf("Unknown release pick policy \"%s\", use ask, best or first", value)
+		return "", errors.New(errorMessage)
+	}
+}
+
+// Asks when there is someone at the terminal to answer and takes the best release otherwise
+func resolveReleasePickPolicy(policy ReleasePickPolicy) ReleasePickPolicy {
+	if policy != "" {
+		return policy
+	}
+
+	if isTerminal(os.Stdin) {
+		return PickAsk
+	}
+
+	return PickBest
+}
+
+func releaseTrackCount(release ReleaseInfo) string {
+	counts := make([]string, len(release.Media))
+	for i, medium := range release.Media {
+		counts[i] = strconv.Itoa(len(medium.Tracks))
+	}
+
+	return strings.Join(counts, "+")
+}
+
+// Writes the candidates as a numbered table, best first
+func printReleaseCandidates(output io.Writer, candidates []ReleaseCandidate) {
+	writer := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "#\tScore\tTOC match\tTitle\tArtist\tDate\tCountry\tLabel\tCatalog number\tBarcode\tMedia\tTracks\tSource")
+
+	for i, candidate := range candidates {
+		release := candidate.Release
+
+		// CD-Text is read from the disc so there is nothing to score
+		score, match := "-", "-"
+		if candidate.Source != SOURCE_CDTEXT {
+			score = fmt.Sprintf("%.0f", candidate.Score)
+			match = fmt.Sprintf("%.0f%%", candidate.Match*100)
+		}
+
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			i+1, score, match, release.Title, artistCreditString(release.Artists), release.Date, release.Country,
+			release.Label, release.CatalogNumber, release.Barcode, len(release.Media), releaseTrackCount(release),
+			candidate.Source)
+	}
+
+	writer.Flush()
+}
+
+// Picks one of the ranked candidates following the policy, prompting on output and reading the answer from input when
+// asking
+func pickRelease(
+	candidates []ReleaseCandidate,
+	policy ReleasePickPolicy,
+	input io.Reader,
+	output io.Writer,
+) (ReleaseCandidate, error) {
+	if len(candidates) == 0 {
+		return ReleaseCandidate{}, ErrNoRelease
+	}
+
+	switch {
+	case len(candidates) == 1 || policy == PickBest:
+		return candidates[0], nil
+	case policy == PickFirst:
+		first := candidates[0]
+		for _, candidate := range candidates {
+			if candidate.Source != SOURCE_CDTEXT && (first.Source == SOURCE_CDTEXT || candidate.Order < first.Order) {
+				first = candidate
+			}
+		}
+		return first, nil
+	}
+
+	fmt.Fprintln(output, "The disc matches several releases:")
+	printReleaseCandidates(output, candidates)
+	if !candidates[0].Confident() {
+		fmt.Fprintf(output, "None of the releases match the disc's track lengths by %.0f%%, check the pick\n",
+			RELEASE_MATCH_THRESHOLD)
+	}
+
+	reader := bufio.NewReader(input)
+	for {
+		fmt.Fprintf(output, "Pick a release [1-%d, q to quit] (1): ", len(candidates))
+		answer, err := reader.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer == "" && err != nil {
+			return ReleaseCandidate{}, errors.New("No release picked")
+		}
+
+		if answer == "" {
+			return candidates[0], nil
+		}
+		if answer == "q" {
+			return ReleaseCandidate{}, errors.New("No release picked")
+		}
+
+		if number, err := strconv.Atoi(answer); err == nil && number >= 1 && number <= len(candidates) {
+			return candidates[number-1], nil
+		}
+		if err != nil {
+			errorMessage := fmt.Sprintf("%s isn't one of the releases", answer)
+			return ReleaseCandidate{}, errors.New(errorMessage)
+		}
+		fmt.Fprintf(output, "%s isn't one of the releases\n", answer)
+	}
+}
+
+// Looks the disc up with the providers and picks the release to tag it with. --release looks the MusicBrainz release
+// up when no provider found it, CD-Text is offered next to the providers' releases when asking and is the fallback
+// when they have none. discNumber is used for releases from sources that don't know which of their discs this is.
+func chooseRelease(
+	options RipOptions,
+	providers []MetadataProvider,
+	client *MusicBrainzClient,
+	disc DiscInfo,
+	discNumber uint8,
+	logger *maokai.FileLogger,
+) (ReleaseInfo, error) {
+	candidates, err := lookupRelease(context.Background(), providers, disc, logger)
+	// A release picked by its MBID can still be looked up on its own
+	if err != nil && options.ReleaseID == "" {
+		return ReleaseInfo{}, err
+	}
+
+	if options.ReleaseID != "" {
+		for _, candidate := range candidates {
+			if candidate.Release.ID == options.ReleaseID {
+				return candidate.Release, nil
+			}
+		}
+
+		message := fmt.Sprintf("Release %s isn't listed for disc %s, looking it up", options.ReleaseID, disc.ID)
+		log.Println(message)
+		logger.CreateLog(message)
+		// discids lists each medium's disc IDs so the disc number can still be worked out
+		includes := append(slices.Clone(DISC_ID_INCLUDES), "discids")
+		release, err := client.LookupRelease(context.Background(), options.ReleaseID, includes)
+		if err != nil {
+			return ReleaseInfo{}, err
+		}
+
+		return releaseFromMusicBrainz(*release), nil
+	}
+
+	policy := resolveReleasePickPolicy(options.Pick)
+	if policy != PickAsk {
+		confident := []ReleaseCandidate{}
+		for _, candidate := range candidates {
+			if candidate.Confident() {
+				confident = append(confident, candidate)
+				continue
+			}
+
+			message := fmt.Sprintf("Not tagging with %s release %s automatically, its track lengths match the disc's "+
+				"by %.0f%% below the %.0f%% threshold", candidate.Source, candidate.SourceID, candidate.Match*100,
+				RELEASE_MATCH_THRESHOLD)
+			log.Println(message)
+			logger.CreateLog(message)
+		}
+		candidates = confident
+	}
+
+	// A disc without a device was ripped earlier and isn't in the drive to read CD-Text from
+	if disc.Device != "" && (len(candidates) == 0 || (policy == PickAsk && len(candidates) > 1)) {
+		cdText, err := CdrdaoCDTextReader{TOC: disc.TOC, Logger: logger}.ReadCDText(disc.Device)
+		if err != nil {
+			logger.CreateErrorLogf("Failed to read CD-Text: %s", err)
+		} else if !cdText.IsEmpty() {
+			candidates = append(candidates, ReleaseCandidate{
+				Release: releaseFromCDText(cdText, disc, discNumber),
+				Source:  SOURCE_CDTEXT,
+			})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return ReleaseInfo{}, errors.New("Disc has no matching release or usable CD-Text, can't tag the rip")
+	}
+
+	candidate, err := pickRelease(candidates, policy, os.Stdin, os.Stdout)
+	if err != nil {
+		return ReleaseInfo{}, err
+	}
+
+	release := candidate.Release
+	release.Source = candidate.Source
+	release.SourceID = candidate.SourceID
+	if media := release.Media; len(media) == 1 && media[0].Position == 0 {
+		media[0].Position = discNumber
+	}
+
+	message := fmt.Sprintf("Tagging with %s release %s by %s", candidate.Source, release.Title,
+		artistCreditString(release.Artists))
+	if candidate.SourceID != "" {
+		message = fmt.Sprintf("%s (%s)", message, candidate.SourceID)
+	}
+	log.Println(message)
+	logger.CreateLog(message)
+
+	return release, nil
+}
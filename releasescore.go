@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Parts of a release's score, adding up to 100
+	RELEASE_SCORE_TOC_WEIGHT        = 80
+	RELEASE_SCORE_DISC_CODE_WEIGHT  = 10
+	RELEASE_SCORE_PREFERENCE_WEIGHT = 10
+	// Track lengths within this many milliseconds of the TOC's count as a full match, MusicBrainz rounds lengths and
+	// some releases count pregaps differently
+	TRACK_LENGTH_TOLERANCE = 1000
+	// Track lengths off by this many milliseconds or more don't match at all
+	TRACK_LENGTH_MAX_DIFFERENCE = 5000
+	// Lowest TOC match in percent a release needs to be picked without asking
+	DEFAULT_RELEASE_MATCH_THRESHOLD = 90
+)
+
+// Which releases to favour when several match the disc
+type ReleasePreferences struct {
+	// Release countries best first e.g. GB, XE
+	Countries []string
+	// Medium formats best first e.g. CD, Enhanced CD
+	Formats []string
+	// earliest or latest to favour the first or last release of the album, empty for neither
+	Date string
+}
+
+// Set with PREFERRED_COUNTRIES, PREFERRED_FORMATS and PREFERRED_RELEASE_DATE
+var RELEASE_PREFERENCES = ReleasePreferences{}
+
+// Set with RELEASE_MATCH_THRESHOLD
+var RELEASE_MATCH_THRESHOLD float64 = DEFAULT_RELEASE_MATCH_THRESHOLD
+
+func splitPreferenceList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// Reads the release preferences and match threshold from the environment
+func loadReleasePreferences() error {
+	RELEASE_PREFERENCES.Countries = splitPreferenceList(os.Getenv("PREFERRED_COUNTRIES"))
+	RELEASE_PREFERENCES.Formats = splitPreferenceList(os.Getenv("PREFERRED_FORMATS"))
+
+	switch date := os.Getenv("PREFERRED_RELEASE_DATE"); date {
+	case "", "earliest", "latest":
+		RELEASE_PREFERENCES.Date = date
+	default:
+		errorMessage := fmt.Sprintf("Unknown PREFERRED_RELEASE_DATE \"%s\", use earliest or latest", date)
+		return errors.New(errorMessage)
+	}
+
+	if thresholdString := os.Getenv("RELEASE_MATCH_THRESHOLD"); thresholdString != "" {
+		threshold, err := strconv.ParseFloat(thresholdString, 64)
+		if err != nil || threshold < 0 || threshold > 100 {
+			errorMessage := fmt.Sprintf("RELEASE_MATCH_THRESHOLD %s isn't a percentage", thresholdString)
+			return errors.New(errorMessage)
+		}
+		RELEASE_MATCH_THRESHOLD = threshold
+	}
+
+	return nil
+}
+
+// Whether the medium could be the disc in the drive, an empty format is MusicBrainz not knowing it
+func isCDFormat(format string) bool {
+	return format == "" || strings.Contains(format, "CD")
+}
+
+// How close a track length from MusicBrainz is to the TOC's, from 1 within TRACK_LENGTH_TOLERANCE down to 0 at
+// TRACK_LENGTH_MAX_DIFFERENCE
+func trackLengthMatch(length uint32, TOCLength uint32) float64 {
+	difference := float64(max(length, TOCLength) - min(length, TOCLength))
+	if difference <= TRACK_LENGTH_TOLERANCE {
+		return 1
+	}
+
+	return max(0, 1-(difference-TRACK_LENGTH_TOLERANCE)/(TRACK_LENGTH_MAX_DIFFERENCE-TRACK_LENGTH_TOLERANCE))
+}
+
+// How well the medium's track lengths match the disc's TOC from 0 to 1. A medium without track lengths matches
+// fully when it lists the disc ID and not at all otherwise.
+func mediumTOCMatch(medium MediumInfo, disc DiscInfo) float64 {
+	if !isCDFormat(medium.Format) || len(medium.Tracks) != disc.TOC.TrackCount() {
+		return 0
+	}
+
+	total, counted := 0.0, 0
+	for i, track := range medium.Tracks {
+		if track.Length == 0 {
+			continue
+		}
+
+		TOCLength := uint32(disc.TOC.TrackSectors(disc.TOC.FirstTrack+i) * 1000 / SECTORS_PER_SECOND)
+		total += trackLengthMatch(track.Length, TOCLength)
+		counted++
+	}
+
+	if counted > 0 {
+		return total / float64(counted)
+	}
+
+	if slices.Contains(medium.DiscIDs, disc.ID) {
+		return 1
+	}
+
+	return 0
+}
+
+// The release's medium best matching the disc and how well it matches
+func bestMediumMatch(release ReleaseInfo, disc DiscInfo) (MediumInfo, float64) {
+	bestMedium, bestMatch := MediumInfo{}, -1.0
+	for _, medium := range release.Media {
+		if match := mediumTOCMatch(medium, disc); match > bestMatch {
+			bestMedium, bestMatch = medium, match
+		}
+	}
+
+	return bestMedium, max(bestMatch, 0)
+}
+
+// Scores a value against a preference list, 1 for the first entry falling towards 0 for the last and 0 when it isn't
+// listed
+func preferenceListScore(value string, list []string) float64 {
+	for i, preferred := range list {
+		if strings.EqualFold(value, preferred) {
+			return 1 - float64(i)/float64(len(list))
+		}
+	}
+
+	return 0
+}
+
+// The earliest and latest of the release dates, MusicBrainz dates are YYYY, YYYY-MM or YYYY-MM-DD so they sort as
+// strings
+func releaseDateRange(releases []ReleaseInfo) (string, string) {
+	earliest, latest := "", ""
+	for _, release := range releases {
+		if release.Date == "" {
+			continue
+		}
+		if earliest == "" || release.Date < earliest {
+			earliest = release.Date
+		}
+		if release.Date > latest {
+			latest = release.Date
+		}
+	}
+
+	return earliest, latest
+}
+
+// How well the release fits the preferences from 0 to 1, the average of the preferences that are set
+func preferenceScore(release ReleaseInfo, medium MediumInfo, earliest string, latest string, preferences ReleasePreferences) float64 {
+	total, counted := 0.0, 0
+
+	if len(preferences.Countries) > 0 {
+		total += preferenceListScore(release.Country, preferences.Countries)
+		counted++
+	}
+
+	if len(preferences.Formats) > 0 {
+		total += preferenceListScore(medium.Format, preferences.Formats)
+		counted++
+	}
+
+	if preferences.Date != "" {
+		target := earliest
+		if preferences.Date == "latest" {
+			target = latest
+		}
+		if release.Date != "" && release.Date == target {
+			total++
+		}
+		counted++
+	}
+
+	if counted == 0 {
+		return 0
+	}
+
+	return total / float64(counted)
+}
+
+// Share of the disc's MCN and ISRCs the release lists from 0 to 1
+func discCodeScore(matches int, disc DiscInfo) float64 {
+	codes := len(disc.ISRCs)
+	if disc.MCN != "" {
+		codes++
+	}
+
+	if codes == 0 {
+		return 0
+	}
+
+	return float64(matches) / float64(codes)
+}
+
+// Scores the releases from source against the disc out of 100. The TOC match carries most of the weight, the disc's
+// MCN and ISRCs and the preferences decide between releases with the same track lengths.
+func scoreReleases(source string, releases []ReleaseInfo, disc DiscInfo, preferences ReleasePreferences) []ReleaseCandidate {
+	earliest, latest := releaseDateRange(releases)
+
+	candidates := make([]ReleaseCandidate, len(releases))
+	for i, release := range releases {
+		medium, match := bestMediumMatch(release, disc)
+		matches := countDiscCodeMatches(release, disc)
+
+		candidates[i] = ReleaseCandidate{
+			Release:         release,
+			Source:          source,
+			Order:           i,
+			Medium:          medium.Position,
+			Match:           match,
+			DiscCodeMatches: matches,
+			Score: RELEASE_SCORE_TOC_WEIGHT*match +
+				RELEASE_SCORE_DISC_CODE_WEIGHT*discCodeScore(matches, disc) +
+				RELEASE_SCORE_PREFERENCE_WEIGHT*preferenceScore(release, medium, earliest, latest, preferences),
+		}
+	}
+
+	return candidates
+}
+
+// Whether the candidate matches the disc closely enough to tag it without asking. CD-Text is read from the disc itself.
+func (candidate ReleaseCandidate) Confident() bool {
+	return candidate.Source == SOURCE_CDTEXT || candidate.Match*100 >= RELEASE_MATCH_THRESHOLD
+}
+
+// Sorts the candidates best first, keeping the order of candidates with the same score
+func sortReleaseCandidates(candidates []ReleaseCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+}
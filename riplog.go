@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mikogd/maokai"
+)
+
+// Everything known about a rip, written as a log into the album folder
+type RipLog struct {
+	Time    time.Time
+	Drive   DriveInfo
+	Disc    DiscInfo
+	Release ReleaseInfo
+	Report  RipReport
+}
+
+var ripLogChecksumPattern = regexp.MustCompile(`(?m)^==== Log checksum ([0-9a-f]{64}) ====\n?$`)
+
+// Formats a length in sectors as m:ss.ff where ff are 1/75th of a second frames
+func formatSectors(sectors int) string {
+	sign := ""
+	if sectors < 0 {
+		sign = "-"
+		sectors = -sectors
+	}
+
+	frames := sectors % SECTORS_PER_SECOND
+	seconds := sectors / SECTORS_PER_SECOND
+
+	return fmt.Sprintf("%s%d:%02d.%02d", sign, seconds/60, seconds%60, frames)
+}
+
+func (ripLog RipLog) Render() string {
+	var builder strings.Builder
+	line := func(format string, a ...any) {
+		fmt.Fprintf(&builder, format+"\n", a...)
+	}
+
+	line("sona rip log %s", ripLog.Time.Format(time.RFC3339))
+	line("")
+	line("Drive:              %s %s (%s)", ripLog.Drive.Vendor, ripLog.Drive.Model, ripLog.Drive.Device)
+	line("Read offset:        %+d", ripLog.Report.Settings.ReadOffset)
+	line("Paranoia mode:      %s", PARANOIA_MODE)
+	if ripLog.Report.Settings.SecureMode {
+		line("Read mode:          Secure, test and copy with up to %d retries", ripLog.Report.Settings.MaxRetries)
+	} else {
+		line("Read mode:          Single read")
+	}
+	if ripLog.Report.Settings.Image {
+		line("Output:             Disc image")
+	} else {
+		line("Output:             File per track")
+	}
+	line("")
+	line("Disc ID:            %s", ripLog.Disc.ID)
+	line("TOC:                %s", ripLog.Disc.TOCString)
+	line("")
+	line("     Track |   Start   |  Length   | Start sector | End sector")
+	line("    ---------------------------------------------------------")
+
+	toc := ripLog.Disc.TOC
+	for trackNumber := toc.FirstTrack; trackNumber <= toc.LastTrack; trackNumber++ {
+		startSector := toc.TrackOffset(trackNumber) - LEAD_IN_SECTORS
+		sectors := toc.TrackSectors(trackNumber)
+		line("       %3d | %9s | %9s | %12d | %10d",
+			trackNumber, formatSectors(startSector), formatSectors(sectors), startSector, startSector+sectors-1)
+	}
+
+	line("")
+
+	if hiddenTrack := ripLog.Report.HiddenTrack; hiddenTrack != nil {
+		if hiddenTrack.Ripped {
+			line("Hidden track:       %s pregap before track 1 ripped as 00. %s", formatSectors(hiddenTrack.Sectors), HTOA_TITLE)
+			line("     Peak level %.1f %%", hiddenTrack.Peak*100)
+			line("     Copy CRC %08X", hiddenTrack.CopyCRC)
+		} else {
+			line("Hidden track:       %s pregap before track 1 is silent", formatSectors(hiddenTrack.Sectors))
+		}
+		line("")
+	}
+
+	line("Release:            %s", ripLog.Release.Title)
+	line("Artist:             %s", artistCreditString(ripLog.Release.Artists))
+	if ripLog.Release.Source != "" {
+		line("Source:             %s", ripLog.Release.Source)
+	}
+	if ripLog.Release.SourceID != "" && ripLog.Release.SourceID != ripLog.Release.ID {
+		line("Source ID:          %s", ripLog.Release.SourceID)
+	}
+	if ripLog.Release.ID != "" {
+		line("MusicBrainz ID:     %s", ripLog.Release.ID)
+	}
+	line("")
+
+	for _, track := range ripLog.Report.Tracks {
+		line("Track %2d", track.TrackNumber)
+		line("")
+		line("     Peak level %.1f %%", track.Peak*100)
+		if ripLog.Report.Settings.SecureMode {
+			line("     Test CRC %08X", track.TestCRC)
+			line("     Copy CRC %08X", track.CopyCRC)
+			if track.Retries == 0 {
+				line("     Copy OK")
+			} else {
+				line("     Copy OK after %d retries", track.Retries)
+			}
+		} else {
+			line("     Copy CRC %08X", track.CopyCRC)
+		}
+
+		if len(track.SuspiciousPositions) == 0 {
+			line("     No suspicious positions")
+		} else {
+			line("     Suspicious positions")
+			for _, position := range track.SuspiciousPositions {
+				line("         %s", formatSectors(position))
+			}
+		}
+
+		accurateRip := ripLog.Report.AccurateRip
+		switch {
+		case accurateRip.LookupError != nil:
+			line("     AccurateRip lookup failed [v1 %08X, v2 %08X]", track.AccurateRip.CRCv1, track.AccurateRip.CRCv2)
+		case !accurateRip.InDatabase:
+			line("     Track not present in AccurateRip database [v1 %08X, v2 %08X]",
+				track.AccurateRip.CRCv1, track.AccurateRip.CRCv2)
+		case track.AccurateRip.Accurate():
+			line("     Accurately ripped (AR v%d, confidence %d) [v1 %08X, v2 %08X]",
+				track.AccurateRip.Version, track.AccurateRip.Confidence, track.AccurateRip.CRCv1, track.AccurateRip.CRCv2)
+		default:
+			line("     Cannot be verified as accurate [v1 %08X, v2 %08X]", track.AccurateRip.CRCv1, track.AccurateRip.CRCv2)
+		}
+		line("")
+	}
+
+	accurateRip := ripLog.Report.AccurateRip
+	switch {
+	case accurateRip.LookupError != nil:
+		line("AccurateRip lookup failed: %s", accurateRip.LookupError)
+	case !accurateRip.InDatabase:
+		line("None of the tracks are present in the AccurateRip database (%s)", accurateRip.DiscID.Path())
+	case len(accurateRip.FailedTracks()) == 0:
+		line("All tracks accurately ripped")
+	default:
+		line("%d of %d tracks cannot be verified as accurate: %v",
+			len(accurateRip.FailedTracks()), len(accurateRip.Tracks), accurateRip.FailedTracks())
+	}
+	line("")
+
+	return builder.String()
+}
+
+func ripLogChecksum(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// Renders the log and appends a checksum of its contents so edits can be detected
+func writeRipLog(filePath string, ripLog RipLog) error {
+	body := ripLog.Render()
+	content := fmt.Sprintf("%s==== Log checksum %s ====\n", body, ripLogChecksum(body))
+
+	return os.WriteFile(filePath, []byte(content), 0644)
+}
+
+// Checks the checksum at the end of a rip log still matches its contents. Anything after the checksum line counts as
+// an edit.
+func verifyRipLog(content string) (bool, error) {
+	locations := ripLogChecksumPattern.FindAllStringSubmatchIndex(content, -1)
+	if len(locations) == 0 {
+		return false, errors.New("Log has no checksum")
+	}
+
+	location := locations[len(locations)-1]
+	if location[1] != len(content) {
+		return false, nil
+	}
+
+	body := content[:location[0]]
+	checksum := content[location[2]:location[3]]
+
+	return ripLogChecksum(body) == checksum, nil
+}
+
+func runVerifyLogCommand(args []string, logger maokai.Logger) uint8 {
+	if len(args) != 1 {
+		log.Println("Usage: sona verify-log <rip log>")
+		return 2
+	}
+
+	content, err := os.ReadFile(args[0])
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to read %s: %s", args[0], err)
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+		return 1
+	}
+
+	valid, err := verifyRipLog(string(content))
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to verify %s: %s", args[0], err)
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+		return 1
+	}
+
+	if !valid {
+		fmt.Printf("%s: checksum mismatch, the log has been edited\n", args[0])
+		return 1
+	}
+
+	fmt.Printf("%s: checksum OK\n", args[0])
+	return 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestRipLog(t *testing.T, release ReleaseInfo) string {
+	t.Helper()
+
+	ripLog := RipLog{
+		Time:  time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Drive: DriveInfo{Device: "/dev/sr0", Vendor: "PLEXTOR", Model: "PX-716A"},
+		Disc: DiscInfo{
+			ID:  "Wn8eRBtfLDfM0qjYPdxrz.Zjs_U-",
+			TOC: DiscTOC{FirstTrack: 1, LastTrack: 2, LeadOut: 30000, Offsets: []int{150, 15000}},
+		},
+		Release: release,
+		Report:  RipReport{Tracks: []TrackReport{{TrackNumber: 1, CopyCRC: 0x1234abcd}, {TrackNumber: 2}}},
+	}
+
+	filePath := filepath.Join(t.TempDir(), "album.log")
+	if err := writeRipLog(filePath, ripLog); err != nil {
+		t.Fatalf("writeRipLog: %v", err)
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(content)
+}
+
+func TestVerifyRipLog(t *testing.T) {
+	content := writeTestRipLog(t, ReleaseInfo{})
+
+	tests := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{"untouched", content, true},
+		{"edited body", strings.Replace(content, "1234ABCD", "1234ABCE", 1), false},
+		{"text after checksum", content + "Track 03 accurately ripped\n", false},
+		{"second checksum after edits", content + "edited\n" + content[strings.LastIndex(content, "===="):], false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			valid, err := verifyRipLog(test.content)
+			if err != nil {
+				t.Fatalf("verifyRipLog: %v", err)
+			}
+			if valid != test.valid {
+				t.Errorf("verifyRipLog = %t, want %t", valid, test.valid)
+			}
+		})
+	}
+}
+
+func TestVerifyRipLogWithoutChecksum(t *testing.T) {
+	content := writeTestRipLog(t, ReleaseInfo{})
+	body := content[:strings.LastIndex(content, "==== Log checksum")]
+
+	if _, err := verifyRipLog(body); err == nil {
+		t.Error("verifyRipLog of a log without a checksum didn't fail")
+	}
+}
+
+func TestRipLogNamesReleaseSource(t *testing.T) {
+	content := writeTestRipLog(t, ReleaseInfo{Title: "Album", Source: SOURCE_DISCOGS, SourceID: "12345"})
+
+	for _, want := range []string{"Source:             Discogs", "Source ID:          12345"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("Rip log is missing %q", want)
+		}
+	}
+	if strings.Contains(content, "MusicBrainz ID:") {
+		t.Error("Rip log of a Discogs release has a MusicBrainz ID line")
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mikogd/maokai"
+)
+
+// A multi-disc release being ripped a disc at a time. It is saved after every disc so the session can be picked up
+// again after a restart.
+type RipSession struct {
+	ReleaseID string
+	Title     string
+	Artist    string
+	// Positions of the release's CD media
+	Discs []uint8
+	// Positions of the media ripped so far
+	Ripped    []uint8
+	StartedAt time.Time
+}
+
+// File the session is saved in. Set with SESSION_PATH, defaults to $XDG_CONFIG_HOME/sona/session.json.
+func ripSessionPath() (string, error) {
+	if sessionPath := os.Getenv("SESSION_PATH"); sessionPath != "" {
+		return sessionPath, nil
+	}
+
+	configDirectory, err := os.UserConfigDir()
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to get config directory: %s", err)
+		return "", errors.New(errorMessage)
+	}
+
+	return filepath.Join(configDirectory, "sona", "session.json"), nil
+}
+
+// Starts a session for the release. Later discs are looked up by the release's MusicBrainz ID so releases from
+// other sources can't start one
+func newRipSession(release ReleaseInfo) (*RipSession, error) {
+	if release.ID == "" {
+		errorMessage := fmt.Sprintf("%s release %s has no MusicBrainz ID to look up the session's other discs with",
+			release.Source, release.Title)
+		return nil, errors.New(errorMessage)
+	}
+
+	session := &RipSession{
+		ReleaseID: release.ID,
+		Title:     release.Title,
+		Artist:    artistCreditString(release.Artists),
+		StartedAt: time.Now(),
+	}
+
+	for i, medium := range release.Media {
+		if !isCDFormat(medium.Format) {
+			continue
+		}
+
+		position := medium.Position
+		if position == 0 {
+			position = uint8(i + 1)
+		}
+		session.Discs = append(session.Discs, position)
+	}
+
+	return session, nil
+}
+
+// Returns the saved session, nil when there is none
+func loadRipSession(sessionPath string) (*RipSession, error) {
+	data, err := os.ReadFile(sessionPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	session := RipSession{}
+	if err := json.Unmarshal(data, &session); err != nil {
+		errorMessage := fmt.Sprintf("Failed to parse session %s: %s", sessionPath, err)
+		return nil, errors.New(errorMessage)
+	}
+
+	return &session, nil
+}
+
+func (session *RipSession) Save(sessionPath string) error {
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sessionPath), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(sessionPath, data, 0644)
+}
+
+// The first disc not ripped yet, 0 once every disc is
+func (session *RipSession) NextDisc() uint8 {
+	for _, disc := range session.Discs {
+		if !slices.Contains(session.Ripped, disc) {
+			return disc
+		}
+	}
+
+	return 0
+}
+
+func (session *RipSession) MarkRipped(discNumber uint8) {
+	if !slices.Contains(session.Ripped, discNumber) {
+		session.Ripped = append(session.Ripped, discNumber)
+	}
+}
+
+// Checks the disc in the drive is one of the session's release's discs and hasn't been ripped yet
+func (session *RipSession) CheckDisc(release ReleaseInfo, disc DiscInfo, discNumber uint8) error {
+	if release.ID != session.ReleaseID {
+		errorMessage := fmt.Sprintf("Disc was matched to release %s but the session is ripping %s", release.ID,
+			session.ReleaseID)
+		return errors.New(errorMessage)
+	}
+
+	if _, found := detectDiscNumber(release, disc); !found {
+		errorMessage := fmt.Sprintf("Disc %s isn't one of the discs of %s, rip it outside the session with --release "+
+			"and a disc number if MusicBrainz is missing it", disc.ID, session.Title)
+		return errors.New(errorMessage)
+	}
+
+	if slices.Contains(session.Ripped, discNumber) {
+		errorMessage := fmt.Sprintf("Disc %d of %s has already been ripped", discNumber, session.Title)
+		return errors.New(errorMessage)
+	}
+
+	return nil
+}
+
+// Asks for the next disc, returning false when the user wants to stop
+func promptForDisc(session *RipSession, input *bufio.Reader, output io.Writer) bool {
+	if session == nil {
+		fmt.Fprint(output, "Insert the first disc and press Enter, q to stop: ")
+	} else {
+		fmt.Fprintf(output, "Insert disc %d of %d of %s by %s and press Enter, q to stop: ", session.NextDisc(),
+			len(session.Discs), session.Title, session.Artist)
+	}
+
+	answer, err := input.ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+
+	return strings.TrimSpace(answer) != "q"
+}
+
+func runSessionCommand(args []string, logger *maokai.FileLogger) uint8 {
+	sessionPath, err := ripSessionPath()
+	if err != nil {
+		log.Println(err)
+		logger.CreateErrorLog(err.Error())
+		return 1
+	}
+
+	if len(args) > 0 && args[0] == "abandon" {
+		if err := os.Remove(sessionPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Println(err)
+			logger.CreateErrorLog(err.Error())
+			return 1
+		}
+
+		log.Println("Session abandoned")
+		return 0
+	}
+
+	options, err := parseRipOptions(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+
+	if options.DiscNumber != "" {
+		log.Println("The session works out disc numbers itself, don't pass one")
+		return 2
+	}
+
+	options.Device, err = resolveCDDrive(options.Device, logger)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to get CD drive: %v", err)
+		logger.CreateErrorLog(errorMessage)
+		log.Println(errorMessage)
+		return 1
+	}
+
+	session, err := loadRipSession(sessionPath)
+	if err != nil {
+		log.Println(err)
+		logger.CreateErrorLog(err.Error())
+		return 1
+	}
+
+	if session != nil {
+		if options.ReleaseID != "" && options.ReleaseID != session.ReleaseID {
+			errorMessage := fmt.Sprintf("A session ripping release %s is in progress, finish it or run sona session "+
+				"abandon", session.ReleaseID)
+			log.Println(errorMessage)
+			logger.CreateErrorLog(errorMessage)
+			return 1
+		}
+
+		message := fmt.Sprintf("Resuming session for %s by %s, %d of %d discs ripped", session.Title, session.Artist,
+			len(session.Ripped), len(session.Discs))
+		log.Println(message)
+		logger.CreateLog(message)
+	}
+
+	input := bufio.NewReader(os.Stdin)
+	for {
+		if !promptForDisc(session, input, os.Stdout) {
+			if session != nil {
+				log.Println("Session saved, run sona session again to carry on")
+			}
+			return 0
+		}
+
+		var rippedDisc uint8
+		discOptions := options
+		if session != nil {
+			discOptions.ReleaseID = session.ReleaseID
+		}
+		discOptions.OnReleaseChosen = func(release ReleaseInfo, disc DiscInfo, discNumber uint8) error {
+			if session == nil {
+				newSession, err := newRipSession(release)
+				if err != nil {
+					return err
+				}
+				session = newSession
+				logger.CreateLogf("Starting session for release %s with discs %v", release.ID, session.Discs)
+			} else if err := session.CheckDisc(release, disc, discNumber); err != nil {
+				return err
+			}
+
+			rippedDisc = discNumber
+			// Saved before ripping so a restart during the first disc still knows the release
+			return session.Save(sessionPath)
+		}
+
+		if code := start(discOptions, logger); code != 0 || rippedDisc == 0 {
+			errorMessage := fmt.Sprintf("Rip failed with code %d, the disc isn't marked as ripped", code)
+			log.Println(errorMessage)
+			logger.CreateErrorLog(errorMessage)
+		} else {
+			session.MarkRipped(rippedDisc)
+			if err := session.Save(sessionPath); err != nil {
+				errorMessage := fmt.Sprintf("Failed to save session %s: %s", sessionPath, err)
+				log.Println(errorMessage)
+				logger.CreateErrorLog(errorMessage)
+				return 1
+			}
+
+			message := fmt.Sprintf("Disc %d of %d of %s ripped", rippedDisc, len(session.Discs), session.Title)
+			log.Println(message)
+			logger.CreateLog(message)
+		}
+
+		if err := ejectDisc(options.Device); err != nil {
+			log.Println(err)
+			logger.CreateErrorLog(err.Error())
+		}
+
+		if session != nil && session.NextDisc() == 0 {
+			if err := os.Remove(sessionPath); err != nil {
+				log.Println(err)
+				logger.CreateErrorLog(err.Error())
+			}
+
+			message := fmt.Sprintf("Every disc of %s has been ripped, session finished", session.Title)
+			log.Println(message)
+			logger.CreateLog(message)
+			return 0
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNewRipSessionNeedsMusicBrainzID(t *testing.T) {
+	release := ReleaseInfo{
+		Title:    "Album",
+		Media:    []MediumInfo{{Position: 1, Format: "CD"}, {Position: 2, Format: "CD"}},
+		Source:   SOURCE_DISCOGS,
+		SourceID: "12345",
+	}
+
+	if _, err := newRipSession(release); err == nil {
+		t.Error("newRipSession started a session for a release without a MusicBrainz ID")
+	}
+
+	release.ID = "b84ee12a-09ef-421b-82de-0441a926375b"
+	session, err := newRipSession(release)
+	if err != nil {
+		t.Fatalf("newRipSession: %v", err)
+	}
+	if !slices.Equal(session.Discs, []uint8{1, 2}) {
+		t.Errorf("Session discs = %v, want [1 2]", session.Discs)
+	}
+}
@@ -1,2 +1,2 @@
 #!/bin/bash
-go run main.go metadata.go cd-rip.go utils.go
+go run main.go metadata.go cd-rip.go utils.go drives.go watch.go disc.go wav.go accuraterip.go offsets.go paranoia.go riplog.go progress.go htoa.go indexes.go cue.go image.go disccodes.go cdtext.go musicbrainz.go metadatacache.go releasepicker.go releasescore.go session.go coverart.go provider.go gnudb.go discogs.go tagsfile.go pending.go submit.go release.go "$@"
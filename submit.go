@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Website disc IDs are submitted to. Set with MUSICBRAINZ_WEB_URL, the web service set with MUSICBRAINZ_URL may be a
+// mirror that can't take edits.
+var MUSICBRAINZ_WEB_URL = "https://musicbrainz.org"
+
+// How often --wait-for-submit looks the disc up again
+const SUBMISSION_POLL_INTERVAL = 30 * time.Second
+
+// Page on MusicBrainz for attaching the disc ID to a release, or adding the release when it isn't there yet
+func discSubmissionURL(disc DiscInfo) string {
+	query := url.Values{}
+	query.Set("id", disc.ID)
+	query.Set("tracks", strconv.Itoa(disc.TOC.TrackCount()))
+	query.Set("toc", disc.TOCString)
+
+	return strings.TrimSuffix(MUSICBRAINZ_WEB_URL, "/") + "/cdtoc/attach?" + query.Encode()
+}
+
+// Opens the URL in $BROWSER, xdg-open when it isn't set, without waiting for the browser to close
+func openInBrowser(pageURL string) error {
+	browser := os.Getenv("BROWSER")
+	if browser == "" {
+		browser = "xdg-open"
+	}
+
+	// Through the shell like the editor so browsers set with arguments work
+	cmd := exec.Command("sh", "-c", browser+` "$1"`, "sh", pageURL)
+	if err := cmd.Start(); err != nil {
+		errorMessage := fmt.Sprintf("Failed to run browser %s: %s", browser, err)
+		return errors.New(errorMessage)
+	}
+	go cmd.Wait()
+
+	return nil
+}
+
+// Tells the user how to add the disc to MusicBrainz, opening the submission page when asked to
+func reportUnknownDisc(provider MusicBrainzProvider, disc DiscInfo) {
+	submissionURL := discSubmissionURL(disc)
+	message := fmt.Sprintf("MusicBrainz doesn't know disc %s, attach it to its release at %s", disc.ID, submissionURL)
+	log.Println(message)
+	provider.Logger.CreateLog(message)
+
+	// A disc without a device was ripped earlier, there is no one at the drive to submit it
+	if !provider.OpenSubmissionURL || disc.Device == "" {
+		return
+	}
+
+	if err := openInBrowser(submissionURL); err != nil {
+		log.Println(err)
+		provider.Logger.CreateErrorLog(err.Error())
+	}
+}
+
+// Looks the disc up on MusicBrainz every SUBMISSION_POLL_INTERVAL until its disc ID has been attached to a release or
+// ctx is done. The cache is skipped, it can't know about the submission yet.
+func waitForSubmission(ctx context.Context, provider MusicBrainzProvider, disc DiscInfo) (*MetaData, error) {
+	message := fmt.Sprintf("Waiting for disc %s to be attached on MusicBrainz, checking every %s",
+		disc.ID, SUBMISSION_POLL_INTERVAL)
+	log.Println(message)
+	provider.Logger.CreateLog(message)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(SUBMISSION_POLL_INTERVAL):
+		}
+
+		metadata, err := GetMetaDataForCD(provider.Client, nil, disc, provider.Logger)
+		if err == nil {
+			message := fmt.Sprintf("Disc %s has been attached on MusicBrainz", disc.ID)
+			log.Println(message)
+			provider.Logger.CreateLog(message)
+			return metadata, nil
+		}
+
+		var notFound *MusicBrainzNotFoundError
+		if !errors.As(err, &notFound) {
+			// The network dropping out while waiting shouldn't end the wait
+			provider.Logger.CreateErrorLogf("Failed to look up disc %s while waiting for it: %s", disc.ID, err)
+		}
+	}
+}
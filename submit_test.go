@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mikogd/maokai"
+)
+
+func TestDiscSubmissionURLCountsTracks(t *testing.T) {
+	// An enhanced CD whose audio starts at track 2
+	disc := DiscInfo{
+		ID:        "Wn8eRBtfLDfM0qjYPdxrz.Zjs_U-",
+		TOCString: "2 4 60000 20000 35000 50000",
+		TOC:       DiscTOC{FirstTrack: 2, LastTrack: 4, LeadOut: 60000, Offsets: []int{20000, 35000, 50000}},
+	}
+
+	submissionURL, err := url.Parse(discSubmissionURL(disc))
+	if err != nil {
+		t.Fatalf("discSubmissionURL isn't a URL: %v", err)
+	}
+	if tracks := submissionURL.Query().Get("tracks"); tracks != "3" {
+		t.Errorf("tracks = %s, want 3", tracks)
+	}
+}
+
+func TestMusicBrainzProviderStopsWaitingForSubmission(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	logger, err := maokai.CreateLogger(maokai.LoggerConfig{LogDirectoryPath: t.TempDir(), LogName: "sona-test.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := MusicBrainzProvider{
+		Client:        newTestMusicBrainzClient(server.URL),
+		Logger:        logger,
+		WaitForSubmit: 50 * time.Millisecond,
+	}
+
+	start := time.Now()
+	_, err = provider.Lookup(context.Background(), testDisc)
+	if !errors.Is(err, ErrNoRelease) {
+		t.Errorf("Lookup returned %v, want ErrNoRelease so the next provider is asked", err)
+	}
+	if waited := time.Since(start); waited > SUBMISSION_POLL_INTERVAL {
+		t.Errorf("Lookup waited %s, want it to give up after 50ms", waited)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mikogd/maokai"
+)
+
+// A disc's tags as a file that can be edited by hand, either to review the tags looked up before they are written or
+// to tag a disc no provider knows with --tags-file. Fields shared by every track are only given once.
+type TagsFile struct {
+	Album          string   `json:"album"`
+	AlbumArtists   []string `json:"album_artists"`
+	AlbumArtistIDs []string `json:"album_artist_ids,omitempty"`
+	ArtistType     string   `json:"artist_type,omitempty"`
+	// Tracks on the disc, defaults to the number of tracks listed
+	TrackTotal uint8 `json:"track_total,omitempty"`
+	// Defaults to the disc number given on the command line or 1
+	DiscNumber uint8 `json:"disc_number,omitempty"`
+	// Defaults to the disc number
+	DiscTotal      uint8    `json:"disc_total,omitempty"`
+	Barcode        string   `json:"barcode,omitempty"`
+	CatalogNumber  string   `json:"catalog_number,omitempty"`
+	ReleaseID      string   `json:"release_id,omitempty"`
+	ReleaseGroupID string   `json:"release_group_id,omitempty"`
+	DiscID         string   `json:"disc_id,omitempty"`
+	ReleaseStatus  string   `json:"release_status,omitempty"`
+	ReleaseType    []string `json:"release_type,omitempty"`
+	ReleaseCountry string   `json:"release_country,omitempty"`
+
+	Tracks []TagsFileTrack `json:"tracks"`
+}
+
+type TagsFileTrack struct {
+	Number      uint8    `json:"number"`
+	Title       string   `json:"title"`
+	Artists     []string `json:"artists"`
+	JoinPhrase  string   `json:"join_phrase,omitempty"`
+	ReleaseDate string   `json:"release_date,omitempty"`
+	Genres      []string `json:"genres,omitempty"`
+	ISRC        string   `json:"isrc,omitempty"`
+	// In milliseconds, worked out from the TOC when left out
+	Length         uint32   `json:"length,omitempty"`
+	ArtistIDs      []string `json:"artist_ids,omitempty"`
+	RecordingID    string   `json:"recording_id,omitempty"`
+	ReleaseTrackID string   `json:"release_track_id,omitempty"`
+}
+
+var ISRCPattern = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$`)
+
+// Builds the file from the songs, the album fields are taken from the first song
+func tagsFileFromSongs(songs []FlacTags) TagsFile {
+	file := TagsFile{Tracks: []TagsFileTrack{}}
+	if len(songs) == 0 {
+		return file
+	}
+
+	first := songs[0]
+	file.Album = first.Album
+	file.AlbumArtists = first.AlbumArtist
+	file.AlbumArtistIDs = first.AlbumArtistIDs
+	file.ArtistType = first.ArtistType
+	file.TrackTotal = first.TrackTotal
+	file.DiscNumber = first.DiscNumber
+	file.DiscTotal = first.DiscTotal
+	file.Barcode = first.Barcode
+	file.CatalogNumber = first.CatalogNumber
+	file.ReleaseID = first.ReleaseID
+	file.ReleaseGroupID = first.ReleaseGroupID
+	file.DiscID = first.DiscID
+	file.ReleaseStatus = first.ReleaseStatus
+	file.ReleaseType = first.ReleaseType
+	file.ReleaseCountry = first.ReleaseCountry
+
+	for _, song := range songs {
+		file.Tracks = append(file.Tracks, TagsFileTrack{
+			Number:         song.TrackNumber,
+			Title:          song.Title,
+			Artists:        song.Artist,
+			JoinPhrase:     song.JoinPhrase,
+			ReleaseDate:    song.ReleaseDate,
+			Genres:         song.Genre,
+			ISRC:           song.ISRC,
+			Length:         song.Length,
+			ArtistIDs:      song.ArtistIDs,
+			RecordingID:    song.RecordingID,
+			ReleaseTrackID: song.ReleaseTrackID,
+		})
+	}
+
+	return file
+}
+
+// Fills in the fields left out of a hand written file from the disc
+func (file *TagsFile) applyDefaults(disc DiscInfo, discNumber uint8) {
+	if file.TrackTotal == 0 {
+		file.TrackTotal = uint8(len(file.Tracks))
+	}
+	if file.DiscNumber == 0 {
+		file.DiscNumber = max(discNumber, 1)
+	}
+	if file.DiscTotal == 0 {
+		file.DiscTotal = file.DiscNumber
+	}
+	if file.DiscID == "" {
+		file.DiscID = disc.ID
+	}
+
+	for i, track := range file.Tracks {
+		trackNumber := int(track.Number)
+		if track.Length == 0 && trackNumber >= disc.TOC.FirstTrack && trackNumber <= disc.TOC.LastTrack {
+			file.Tracks[i].Length = uint32(disc.TOC.TrackSectors(trackNumber) * 1000 / SECTORS_PER_SECOND)
+		}
+	}
+}
+
+// Checks the file tags every track of the disc once and has the fields every song needs
+func (file TagsFile) Validate(disc DiscInfo) error {
+	problems := []string{}
+	if strings.TrimSpace(file.Album) == "" {
+		problems = append(problems, "album is empty")
+	}
+	if len(file.AlbumArtists) == 0 || strings.TrimSpace(file.AlbumArtists[0]) == "" {
+		problems = append(problems, "album_artists is empty")
+	}
+	if file.DiscNumber == 0 {
+		problems = append(problems, "disc_number is missing")
+	}
+	if file.DiscTotal != 0 && file.DiscNumber > file.DiscTotal {
+		problems = append(problems, fmt.Sprintf("disc_number %d is after disc_total %d", file.DiscNumber, file.DiscTotal))
+	}
+	if file.ReleaseID != "" && len(file.ReleaseID) != 36 {
+		problems = append(problems, fmt.Sprintf("release_id %s isn't an MBID", file.ReleaseID))
+	}
+
+	seen := map[int]bool{}
+	for _, track := range file.Tracks {
+		trackNumber := int(track.Number)
+		switch {
+		case trackNumber < disc.TOC.FirstTrack || trackNumber > disc.TOC.LastTrack:
+			problems = append(problems, fmt.Sprintf("track %d isn't on the disc, it has tracks %d to %d", trackNumber,
+				disc.TOC.FirstTrack, disc.TOC.LastTrack))
+		case seen[trackNumber]:
+			problems = append(problems, fmt.Sprintf("track %d is listed more than once", trackNumber))
+		}
+		seen[trackNumber] = true
+
+		if strings.TrimSpace(track.Title) == "" {
+			problems = append(problems, fmt.Sprintf("track %d has no title", trackNumber))
+		}
+		if len(track.Artists) == 0 || strings.TrimSpace(track.Artists[0]) == "" {
+			problems = append(problems, fmt.Sprintf("track %d has no artists", trackNumber))
+		}
+		if track.ISRC != "" && !ISRCPattern.MatchString(track.ISRC) {
+			problems = append(problems, fmt.Sprintf("track %d ISRC %s isn't 12 characters like GBAYE0000351", trackNumber,
+				track.ISRC))
+		}
+	}
+
+	for trackNumber := disc.TOC.FirstTrack; trackNumber <= disc.TOC.LastTrack; trackNumber++ {
+		if !seen[trackNumber] {
+			problems = append(problems, fmt.Sprintf("track %d is missing", trackNumber))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
+
+// The songs the file describes, ordered by track number
+func (file TagsFile) Songs() []FlacTags {
+	songs := []FlacTags{}
+	for _, track := range file.Tracks {
+		songs = append(songs, FlacTags{
+			Title:          track.Title,
+			Artist:         track.Artists,
+			Album:          file.Album,
+			AlbumArtist:    file.AlbumArtists,
+			TrackNumber:    track.Number,
+			TrackTotal:     file.TrackTotal,
+			DiscNumber:     file.DiscNumber,
+			DiscTotal:      file.DiscTotal,
+			ReleaseDate:    track.ReleaseDate,
+			Length:         track.Length,
+			Genre:          track.Genres,
+			JoinPhrase:     track.JoinPhrase,
+			ArtistType:     file.ArtistType,
+			ISRC:           track.ISRC,
+			Barcode:        file.Barcode,
+			CatalogNumber:  file.CatalogNumber,
+			ReleaseID:      file.ReleaseID,
+			ReleaseTrackID: track.ReleaseTrackID,
+			RecordingID:    track.RecordingID,
+			ArtistIDs:      track.ArtistIDs,
+			AlbumArtistIDs: file.AlbumArtistIDs,
+			ReleaseGroupID: file.ReleaseGroupID,
+			DiscID:         file.DiscID,
+			ReleaseStatus:  file.ReleaseStatus,
+			ReleaseType:    file.ReleaseType,
+			ReleaseCountry: file.ReleaseCountry,
+		})
+	}
+
+	slices.SortFunc(songs, func(a, b FlacTags) int {
+		return int(a.TrackNumber) - int(b.TrackNumber)
+	})
+
+	return songs
+}
+
+func readTagsFile(fileName string) (TagsFile, error) {
+	reader, err := os.Open(fileName)
+	if err != nil {
+		return TagsFile{}, err
+	}
+	defer reader.Close()
+
+	// A misspelt field would otherwise be dropped without a word
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+
+	file := TagsFile{}
+	if err := decoder.Decode(&file); err != nil {
+		errorMessage := fmt.Sprintf("Failed to parse tags file %s: %s", fileName, err)
+		return TagsFile{}, errors.New(errorMessage)
+	}
+
+	return file, nil
+}
+
+func writeTagsFile(fileName string, file TagsFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fileName, append(data, '\n'), 0644)
+}
+
+// Reads and validates a hand written tags file for the disc
+func loadTagsFile(fileName string, disc DiscInfo, discNumber uint8) (TagsFile, error) {
+	file, err := readTagsFile(fileName)
+	if err != nil {
+		return TagsFile{}, err
+	}
+
+	file.applyDefaults(disc, discNumber)
+	if err := file.Validate(disc); err != nil {
+		errorMessage := fmt.Sprintf("Tags file %s isn't valid:\n%s", fileName, err)
+		return TagsFile{}, errors.New(errorMessage)
+	}
+
+	return file, nil
+}
+
+// Builds the release the rest of the rip needs from a tags file, a single medium holding the disc
+func releaseFromTagsFile(file TagsFile) ReleaseInfo {
+	release := ReleaseInfo{ID: file.ReleaseID, Title: file.Album, Source: SOURCE_TAGS_FILE}
+	setAlbumArtists(&release, file.AlbumArtists, file.AlbumArtistIDs, file.ArtistType)
+
+	medium := MediumInfo{Position: file.DiscNumber, Format: "CD"}
+	for _, song := range file.Songs() {
+		medium.Tracks = append(medium.Tracks, TrackInfo{
+			Number: strconv.Itoa(int(song.TrackNumber)),
+			Title:  song.Title,
+			Length: song.Length,
+		})
+	}
+	release.Media = []MediumInfo{medium}
+
+	return release
+}
+
+// Opens the file in $VISUAL or $EDITOR, vi when neither is set, and waits for the editor to close
+func openInEditor(fileName string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// Through the shell so editors set with arguments e.g. "code --wait" work
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", fileName)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		errorMessage := fmt.Sprintf("Failed to run editor %s: %s", editor, err)
+		return errors.New(errorMessage)
+	}
+
+	return nil
+}
+
+// Writes the songs' tags to a file, opens it in the editor and reads the edits back, asking to edit again until the
+// file is valid. Returns an error when the user gives up.
+func reviewFlacTags(songs []FlacTags, disc DiscInfo, logger maokai.Logger) ([]FlacTags, error) {
+	tempFile, err := os.CreateTemp("", "sona-tags-*.json")
+	if err != nil {
+		return nil, err
+	}
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	if err := writeTagsFile(tempFile.Name(), tagsFileFromSongs(songs)); err != nil {
+		return nil, err
+	}
+
+	input := bufio.NewReader(os.Stdin)
+	for {
+		if err := openInEditor(tempFile.Name()); err != nil {
+			return nil, err
+		}
+
+		file, err := readTagsFile(tempFile.Name())
+		if err == nil {
+			err = file.Validate(disc)
+		}
+		if err == nil {
+			logger.CreateLogf("Tags reviewed in %s", tempFile.Name())
+			return file.Songs(), nil
+		}
+
+		log.Printf("The tags aren't valid:\n%s\n", err)
+		fmt.Print("Edit them again? [Y/n] ")
+		answer, readErr := input.ReadString('\n')
+		if readErr != nil || strings.EqualFold(strings.TrimSpace(answer), "n") {
+			return nil, errors.New("Tags review abandoned")
+		}
+	}
+}
+
+// Carries album title, artist and disc number edits over to the release the album folder and file names are built
+// from. Only a release with a single medium has its position moved, other releases already list the edited disc.
+func applyAlbumTags(release *ReleaseInfo, songs []FlacTags) {
+	if len(songs) == 0 {
+		return
+	}
+
+	release.Title = songs[0].Album
+	if len(release.Media) == 1 {
+		release.Media[0].Position = songs[0].DiscNumber
+	}
+	if slices.Equal(songs[0].AlbumArtist, artistNames(release.Artists)) {
+		return
+	}
+
+	setAlbumArtists(release, songs[0].AlbumArtist, songs[0].AlbumArtistIDs, songs[0].ArtistType)
+}
+
+// Credits the release to the named artists, IDs are matched to the names by position
+func setAlbumArtists(release *ReleaseInfo, names []string, IDs []string, artistType string) {
+	release.Artists = []CreditedArtist{}
+	for i, name := range names {
+		artist := CreditedArtist{Name: name, Type: artistType}
+		if i < len(IDs) {
+			artist.ID = IDs[i]
+		}
+		release.Artists = append(release.Artists, artist)
+	}
+}
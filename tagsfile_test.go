@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestTagsFile(t *testing.T, content string) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "tags.json")
+	if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return fileName
+}
+
+func TestReadTagsFileRejectsUnknownFields(t *testing.T) {
+	fileName := writeTestTagsFile(t, `{
+  "album": "Album",
+  "album_artists": ["Artist"],
+  "disc_numbr": 2,
+  "tracks": [{"number": 1, "title": "First", "artists": ["Artist"]}]
+}`)
+
+	_, err := readTagsFile(fileName)
+	if err == nil || !strings.Contains(err.Error(), "disc_numbr") {
+		t.Errorf("readTagsFile returned %v, want an error naming disc_numbr", err)
+	}
+}
+
+func TestReadTagsFile(t *testing.T) {
+	fileName := writeTestTagsFile(t, `{
+  "album": "Album",
+  "album_artists": ["Artist"],
+  "disc_number": 2,
+  "tracks": [{"number": 1, "title": "First", "artists": ["Artist"]}]
+}`)
+
+	file, err := readTagsFile(fileName)
+	if err != nil {
+		t.Fatalf("readTagsFile: %v", err)
+	}
+	if file.Album != "Album" || file.DiscNumber != 2 || len(file.Tracks) != 1 {
+		t.Errorf("readTagsFile returned %+v", file)
+	}
+}
+
+func TestApplyAlbumTagsMovesSingleMedium(t *testing.T) {
+	release := ReleaseInfo{
+		Title:   "Album",
+		Artists: []CreditedArtist{{Name: "Artist"}},
+		Media:   []MediumInfo{{Position: 1, Format: "CD"}},
+	}
+	songs := []FlacTags{{Album: "Edited", AlbumArtist: []string{"Artist"}, DiscNumber: 2}}
+
+	applyAlbumTags(&release, songs)
+
+	if release.Title != "Edited" || release.Media[0].Position != 2 {
+		t.Errorf("applyAlbumTags left %s with medium %d, want Edited with medium 2", release.Title,
+			release.Media[0].Position)
+	}
+}
+
+func TestReleaseFromTagsFile(t *testing.T) {
+	file := TagsFile{
+		Album:        "Album",
+		AlbumArtists: []string{"Artist"},
+		DiscNumber:   2,
+		Tracks:       []TagsFileTrack{{Number: 1, Title: "First"}, {Number: 2, Title: "Second"}},
+	}
+
+	release := releaseFromTagsFile(file)
+	if release.Source != SOURCE_TAGS_FILE || releaseArtistName(release) != "Artist" {
+		t.Errorf("releaseFromTagsFile returned %s by %s, want a tags file release by Artist", release.Source,
+			releaseArtistName(release))
+	}
+	if len(release.Media) != 1 || release.Media[0].Position != 2 || len(release.Media[0].Tracks) != 2 {
+		t.Errorf("releaseFromTagsFile returned media %+v, want disc 2 with 2 tracks", release.Media)
+	}
+}
@@ -35,31 +35,22 @@ func getDevInfo(dev string) ([]byte, error) {
 	return out, nil
 }
 
-func checkIsCDROM(dev string) (bool, error) {
-	out, err := getDevInfo(dev)
-	if err != nil {
-		errorMessage := fmt.Sprintf("Error getting CD device\n%v", err)
-		return false, errors.New(errorMessage)
-	}
+// Parses the KEY=VALUE lines printed by `udevadm info --query=property` into a map
+func parseDevInfo(out []byte) map[string]string {
+	properties := map[string]string{}
 
 	scanner := bufio.NewScanner(bytes.NewReader(out))
-	isCDROM := false
 	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Trim(line, " \t\r\n") == "ID_CDROM_BD=1" {
-			return false, nil
-		}
-
-		if strings.Trim(line, " \t\r\n") == "ID_CDROM=1" {
-			isCDROM = true
+		line := strings.Trim(scanner.Text(), " \t\r\n")
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
 		}
-	}
 
-	if isCDROM {
-		return true, nil
+		properties[key] = value
 	}
 
-	return false, nil
+	return properties
 }
 
 func getCDDriveDeviceName(logger maokai.Logger) (string, error) {
@@ -73,14 +64,14 @@ func getCDDriveDeviceName(logger maokai.Logger) (string, error) {
 
 	// Check which are actual CD/DVD drives
 	for _, dev := range matches {
-		isCDROM, err := checkIsCDROM(dev)
+		info, err := getDriveInfo(dev)
 
 		if err != nil {
 			errorMessage := fmt.Sprintf("Failed to find CD drive\n%v", err)
 			return "", errors.New(errorMessage)
 		}
 
-		if isCDROM {
+		if info.CanReadCD() {
 			logger.CreateLog(fmt.Sprintf("Found CD drive %s", dev))
 			return dev, nil
 		}
@@ -99,3 +90,16 @@ func sanitizeSongName(logger maokai.Logger, songName string) string {
 
 	return sanitizedSongName
 }
+
+// Writes everything written to it to the logger, one log per write
+type loggerWriter struct {
+	logger maokai.Logger
+}
+
+func (w loggerWriter) Write(p []byte) (int, error) {
+	if err := w.logger.CreateLog(string(p)); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
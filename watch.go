@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/mikogd/maokai"
+)
+
+type MediaEventType int
+
+const (
+	MediaInserted MediaEventType = iota
+	MediaRemoved
+)
+
+func (t MediaEventType) String() string {
+	if t == MediaInserted {
+		return "inserted"
+	}
+
+	return "removed"
+}
+
+// A disc being inserted into or removed from a drive
+type MediaEvent struct {
+	Type        MediaEventType
+	Device      string
+	AudioTracks int
+}
+
+// Source of media change events for the watched drives. The channel is closed once ctx is done.
+type MediaEventSource interface {
+	Events(ctx context.Context) (<-chan MediaEvent, error)
+}
+
+// Polls the udev properties of the drives and reports changes to ID_CDROM_MEDIA
+type UdevPollingSource struct {
+	Devices  []string
+	Interval time.Duration
+	Logger   maokai.Logger
+}
+
+func (s *UdevPollingSource) poll(ctx context.Context, mediaPresent map[string]bool, events chan<- MediaEvent) {
+	for _, dev := range s.Devices {
+		info, err := getDriveInfo(dev)
+		if err != nil {
+			s.Logger.CreateErrorLogf("Failed to poll %s: %s", dev, err)
+			continue
+		}
+
+		if info.MediaPresent == mediaPresent[dev] {
+			continue
+		}
+		mediaPresent[dev] = info.MediaPresent
+
+		event := MediaEvent{Type: MediaRemoved, Device: dev}
+		if info.MediaPresent {
+			event.Type = MediaInserted
+			event.AudioTracks, _ = strconv.Atoi(info.Properties["ID_CDROM_MEDIA_TRACK_COUNT_AUDIO"])
+		}
+
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *UdevPollingSource) Events(ctx context.Context) (<-chan MediaEvent, error) {
+	if len(s.Devices) == 0 {
+		return nil, errors.New("No devices to watch")
+	}
+
+	events := make(chan MediaEvent, len(s.Devices))
+
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+
+		// Discs already in a drive when the watcher starts are reported as inserted
+		mediaPresent := map[string]bool{}
+		for {
+			s.poll(ctx, mediaPresent, events)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+func ejectDisc(device string) error {
+	cmd := exec.Command("eject", device)
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Failed to run eject %s: %s", device, err)
+	}
+
+	return nil
+}
+
+// Runs rip for every audio disc inserted and ejects it once done, until ctx is done or the source stops
+func watchDrives(
+	ctx context.Context,
+	source MediaEventSource,
+	rip func(device string) uint8,
+	eject func(device string) error,
+	logger maokai.Logger,
+) error {
+	events, err := source.Events(ctx)
+	if err != nil {
+		return err
+	}
+
+	for event := range events {
+		logger.CreateLogf("Media %s on %s with %d audio tracks", event.Type, event.Device, event.AudioTracks)
+
+		if event.Type != MediaInserted {
+			continue
+		}
+
+		if event.AudioTracks == 0 {
+			message := fmt.Sprintf("Disc in %s has no audio tracks, ignoring", event.Device)
+			log.Println(message)
+			logger.CreateLog(message)
+			continue
+		}
+
+		log.Printf("Audio disc inserted in %s, starting rip\n", event.Device)
+		if code := rip(event.Device); code != 0 {
+			errorMessage := fmt.Sprintf("Rip of disc in %s failed with code %d", event.Device, code)
+			log.Println(errorMessage)
+			logger.CreateErrorLog(errorMessage)
+		} else {
+			message := fmt.Sprintf("Rip of disc in %s finished", event.Device)
+			log.Println(message)
+			logger.CreateLog(message)
+		}
+
+		if err := eject(event.Device); err != nil {
+			log.Println(err)
+			logger.CreateErrorLog(err.Error())
+		}
+
+		log.Println("Waiting for the next disc")
+	}
+
+	return nil
+}
+
+func runWatchCommand(args []string, logger *maokai.FileLogger) uint8 {
+	var device string
+	var interval time.Duration
+
+	flags := flag.NewFlagSet("sona watch", flag.ContinueOnError)
+	flags.StringVar(&device, "device", "", "only watch this CD drive, defaults to every CD drive found")
+	flags.DurationVar(&interval, "interval", 2*time.Second, "how often the drives are polled for media changes")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var devices []string
+	if device != "" {
+		resolvedDevice, err := resolveCDDrive(device, logger)
+		if err != nil {
+			log.Println(err)
+			logger.CreateErrorLog(err.Error())
+			return 1
+		}
+		devices = append(devices, resolvedDevice)
+	} else {
+		drives, err := listDrives(logger)
+		if err != nil {
+			log.Println(err)
+			logger.CreateErrorLog(err.Error())
+			return 1
+		}
+
+		for _, drive := range drives {
+			if drive.CanReadCD() {
+				devices = append(devices, drive.Device)
+			}
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	source := &UdevPollingSource{Devices: devices, Interval: interval, Logger: logger}
+	rip := func(device string) uint8 {
+		// Nobody is there to answer a prompt while watching
+		return start(RipOptions{Device: device, Pick: PickBest}, logger)
+	}
+
+	message := fmt.Sprintf("Watching %v for audio discs", devices)
+	log.Println(message)
+	logger.CreateLog(message)
+
+	if err := watchDrives(ctx, source, rip, ejectDisc, logger); err != nil {
+		errorMessage := fmt.Sprintf("Failed to watch drives: %s", err)
+		log.Println(errorMessage)
+		logger.CreateErrorLog(errorMessage)
+		return 1
+	}
+
+	return 0
+}
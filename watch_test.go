@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// Sends a fixed list of events then closes the channel
+type fakeMediaEventSource struct {
+	events []MediaEvent
+	err    error
+}
+
+func (s fakeMediaEventSource) Events(ctx context.Context) (<-chan MediaEvent, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	events := make(chan MediaEvent, len(s.events))
+	for _, event := range s.events {
+		events <- event
+	}
+	close(events)
+
+	return events, nil
+}
+
+func TestWatchDrivesRipsAudioDiscs(t *testing.T) {
+	source := fakeMediaEventSource{events: []MediaEvent{
+		{Type: MediaInserted, Device: "/dev/sr0", AudioTracks: 12},
+		{Type: MediaRemoved, Device: "/dev/sr0"},
+		// Data discs are left alone
+		{Type: MediaInserted, Device: "/dev/sr1", AudioTracks: 0},
+		{Type: MediaInserted, Device: "/dev/sr1", AudioTracks: 3},
+	}}
+
+	ripped := []string{}
+	rip := func(device string) uint8 {
+		ripped = append(ripped, device)
+		// A failed rip still ejects the disc and carries on
+		if device == "/dev/sr1" {
+			return 1
+		}
+		return 0
+	}
+
+	ejected := []string{}
+	eject := func(device string) error {
+		ejected = append(ejected, device)
+		return nil
+	}
+
+	if err := watchDrives(context.Background(), source, rip, eject, discardLogger{}); err != nil {
+		t.Fatalf("watchDrives returned %v", err)
+	}
+
+	want := []string{"/dev/sr0", "/dev/sr1"}
+	if !slices.Equal(ripped, want) {
+		t.Errorf("ripped %v, want %v", ripped, want)
+	}
+	if !slices.Equal(ejected, want) {
+		t.Errorf("ejected %v, want %v", ejected, want)
+	}
+}
+
+func TestWatchDrivesSourceError(t *testing.T) {
+	sourceErr := errors.New("no drives")
+	source := fakeMediaEventSource{err: sourceErr}
+
+	rip := func(device string) uint8 {
+		t.Errorf("ripped %s without any events", device)
+		return 0
+	}
+	eject := func(device string) error { return nil }
+
+	if err := watchDrives(context.Background(), source, rip, eject, discardLogger{}); !errors.Is(err, sourceErr) {
+		t.Errorf("watchDrives returned %v, want %v", err, sourceErr)
+	}
+}
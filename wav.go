@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Bytes of a 16 bit stereo sample
+const WAV_BYTES_PER_SAMPLE = 4
+
+// Finds the position and size in bytes of the data chunk of a WAV file
+func findWAVDataChunk(file *os.File) (int64, int64, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil || string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		errorMessage := fmt.Sprintf("%s is not a WAV file", file.Name())
+		return 0, 0, errors.New(errorMessage)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Walk the chunks after the RIFF header until the data chunk is found
+	position := int64(12)
+	chunkHeader := make([]byte, 8)
+	for position+8 <= info.Size() {
+		if _, err := file.ReadAt(chunkHeader, position); err != nil {
+			return 0, 0, err
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+		position += 8
+
+		if chunkID != "data" {
+			// Chunks are padded to an even size
+			position += chunkSize + chunkSize%2
+			continue
+		}
+
+		if position+chunkSize > info.Size() {
+			chunkSize = info.Size() - position
+		}
+
+		return position, chunkSize, nil
+	}
+
+	errorMessage := fmt.Sprintf("%s has no data chunk", file.Name())
+	return 0, 0, errors.New(errorMessage)
+}
+
+// Reads the PCM data of a 16 bit stereo WAV file as written by cdparanoia. Each sample holds the left channel in the
+// low 16 bits and the right channel in the high 16 bits, the layout AccurateRip checksums are computed over.
+func readWAVSamples(fileName string) ([]uint32, error) {
+	return readWAVSampleRange(fileName, 0, -1)
+}
+
+// Reads count samples of a WAV file starting at sample start, a negative count reads up to the end of the file. Lets
+// a single track be read out of a whole disc image without loading all of it.
+func readWAVSampleRange(fileName string, start int, count int) ([]uint32, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dataPosition, dataSize, err := findWAVDataChunk(file)
+	if err != nil {
+		return nil, err
+	}
+
+	available := int(dataSize/WAV_BYTES_PER_SAMPLE) - start
+	if count < 0 || count > available {
+		count = available
+	}
+	if count < 0 {
+		errorMessage := fmt.Sprintf("%s has no sample %d", fileName, start)
+		return nil, errors.New(errorMessage)
+	}
+
+	data := make([]byte, count*WAV_BYTES_PER_SAMPLE)
+	if _, err := file.ReadAt(data, dataPosition+int64(start*WAV_BYTES_PER_SAMPLE)); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	samples := make([]uint32, count)
+	for i := range samples {
+		samples[i] = binary.LittleEndian.Uint32(data[i*WAV_BYTES_PER_SAMPLE:])
+	}
+
+	return samples, nil
+}
+
+// Returns the CRC32 of the PCM data and its peak level as a fraction of full scale
+func computePCMStats(samples []uint32) (uint32, float64) {
+	var CRC uint32
+	var peak int32
+
+	buffer := make([]byte, 0, 4*SAMPLES_PER_SECTOR)
+	for i, sample := range samples {
+		buffer = binary.LittleEndian.AppendUint32(buffer, sample)
+		if len(buffer) == cap(buffer) || i == len(samples)-1 {
+			CRC = crc32.Update(CRC, crc32.IEEETable, buffer)
+			buffer = buffer[:0]
+		}
+
+		left, right := int32(int16(sample)), int32(int16(sample>>16))
+		peak = max(peak, left, -left, right, -right)
+	}
+
+	return CRC, float64(peak) / 32768
+}